@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newAdminHandler returns the handler of the admin API, used to change the
+// behaviour of the server at runtime. If token is not empty, requests must
+// present it as a bearer token.
+//
+//	GET    /features              list the features and whether they are enabled
+//	PUT    /features/{name}       enable a feature, unless only read on startup
+//	DELETE /features/{name}       disable a feature, unless only read on startup
+//	GET    /defaults              list the query parameter defaults
+//	PUT    /defaults/{name}       set the default of a query parameter to the body
+//	DELETE /defaults/{name}       clear the default of a query parameter
+//	GET    /health                list the health probes and their state
+//	PUT    /health/{probe}        set a health probe up
+//	DELETE /health/{probe}        set a health probe down
+//	GET    /grpc-health           list the gRPC services and their health
+//	PUT    /grpc-health/{service} set a gRPC service serving
+//	DELETE /grpc-health/{service} set a gRPC service not serving
+func newAdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /features", listFeatures)
+	mux.HandleFunc("PUT /features/{name}", setFeature(true))
+	mux.HandleFunc("DELETE /features/{name}", setFeature(false))
+	mux.HandleFunc("GET /defaults", listDefaults)
+	mux.HandleFunc("PUT /defaults/{name}", putDefault)
+	mux.HandleFunc("DELETE /defaults/{name}", deleteDefault)
+	mux.HandleFunc("GET /health", listHealth)
+	mux.HandleFunc("PUT /health/{probe}", setHealth(true))
+	mux.HandleFunc("DELETE /health/{probe}", setHealth(false))
+	mux.HandleFunc("GET /grpc-health", listGRPCHealth)
+	mux.HandleFunc("PUT /grpc-health/{service}", setGRPCHealth(true))
+	mux.HandleFunc("DELETE /grpc-health/{service}", setGRPCHealth(false))
+
+	if token == "" {
+		return mux
+	}
+
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		presented, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			wr.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(wr, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(wr, req)
+	})
+}
+
+func listFeatures(wr http.ResponseWriter, req *http.Request) {
+	writeJSON(wr, http.StatusOK, feature.All())
+}
+
+func setFeature(enabled bool) http.HandlerFunc {
+	return func(wr http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("name")
+		if !feature.Known(name) {
+			http.Error(wr, fmt.Sprintf("unknown feature %q", name), http.StatusNotFound)
+			return
+		}
+		if !isRuntimeFeature(name) {
+			http.Error(wr, fmt.Sprintf("feature %q can only be set on startup", name), http.StatusConflict)
+			return
+		}
+
+		feature.Set(name, enabled)
+		slog.InfoContext(req.Context(), "feature changed", "feature", name, "enabled", enabled)
+		writeJSON(wr, http.StatusOK, feature.All())
+	}
+}
+
+func listDefaults(wr http.ResponseWriter, req *http.Request) {
+	writeJSON(wr, http.StatusOK, allParamDefaults())
+}
+
+func putDefault(wr http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if !isDefaultParam(name) {
+		http.Error(wr, fmt.Sprintf("%q does not take a default", name), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, 1024))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value := strings.TrimSpace(string(body))
+
+	switch name {
+	case "delay", "think", "timeout":
+		if _, err := time.ParseDuration(value); err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "status", "abort", "reset":
+		if err := checkFault(name, value); err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	setParamDefault(name, value)
+	slog.InfoContext(req.Context(), "default changed", "param", name, "value", value)
+	writeJSON(wr, http.StatusOK, allParamDefaults())
+}
+
+func deleteDefault(wr http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if !isDefaultParam(name) {
+		http.Error(wr, fmt.Sprintf("%q does not take a default", name), http.StatusNotFound)
+		return
+	}
+
+	clearParamDefault(name)
+	slog.InfoContext(req.Context(), "default cleared", "param", name)
+	writeJSON(wr, http.StatusOK, allParamDefaults())
+}
+
+func listHealth(wr http.ResponseWriter, req *http.Request) {
+	writeJSON(wr, http.StatusOK, healthStates())
+}
+
+func setHealth(up bool) http.HandlerFunc {
+	return func(wr http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("probe")
+		p, ok := probes[name]
+		if !ok {
+			http.Error(wr, fmt.Sprintf("unknown probe %q", name), http.StatusNotFound)
+			return
+		}
+
+		p.Set(up)
+		slog.InfoContext(req.Context(), "probe changed", "probe", name, "up", up)
+		writeJSON(wr, http.StatusOK, healthStates())
+	}
+}
+
+func listGRPCHealth(wr http.ResponseWriter, req *http.Request) {
+	writeJSON(wr, http.StatusOK, grpcHealthServer.States())
+}
+
+func setGRPCHealth(up bool) http.HandlerFunc {
+	return func(wr http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("service")
+		grpcHealthServer.Set(name, up)
+		slog.InfoContext(req.Context(), "grpc health changed", "service", name, "up", up)
+		writeJSON(wr, http.StatusOK, grpcHealthServer.States())
+	}
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(wr http.ResponseWriter, code int, v any) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(code)
+	enc := json.NewEncoder(wr)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// maxBodySize is the largest request body accepted, if positive, set by
+// MAX_BODY_SIZE.
+var maxBodySize int64
+
+// logBodyLimit is how much of a request body LOG_HTTP_BODY dumps.
+const logBodyLimit = 64 << 10
+
+// sniffLen is the number of bytes used to detect the content type of a body.
+const sniffLen = 512
+
+// bodyInfo reports the size and digests of a request body, computed as it is
+// read rather than by buffering it.
+type bodyInfo struct {
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	MD5          string `json:"md5"`
+	CRC32        string `json:"crc32"`
+	ContentType  string `json:"contentType,omitempty"`
+	DetectedType string `json:"detectedType,omitempty"`
+}
+
+func (b *bodyInfo) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int64("size", b.Size),
+		slog.String("sha256", b.SHA256),
+		slog.String("md5", b.MD5),
+		slog.String("crc32", b.CRC32),
+		slog.String("contentType", b.ContentType),
+		slog.String("detectedType", b.DetectedType),
+	)
+}
+
+func (b *bodyInfo) summary() string {
+	s := fmt.Sprintf("%d bytes | sha256 %s | md5 %s | crc32 %s", b.Size, b.SHA256, b.MD5, b.CRC32)
+	if b.ContentType != "" {
+		s += " | " + b.ContentType
+	}
+	if b.DetectedType != "" {
+		s += " | detected " + b.DetectedType
+	}
+	return s
+}
+
+// digestReader digests a request body as it is read, keeping its first bytes
+// to detect its content type and for logging.
+type digestReader struct {
+	io.ReadCloser
+
+	contentType string
+	size        int64
+	sha256      hash.Hash
+	md5         hash.Hash
+	crc32       hash.Hash32
+
+	keep int    // how many of the first bytes to keep
+	head []byte // the first bytes read
+}
+
+func newDigestReader(req *http.Request, keep int) *digestReader {
+	return &digestReader{
+		ReadCloser:  req.Body,
+		contentType: req.Header.Get("Content-Type"),
+		sha256:      sha256.New(),
+		md5:         md5.New(),
+		crc32:       crc32.NewIEEE(),
+		keep:        max(keep, sniffLen),
+	}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.size += int64(n)
+		r.sha256.Write(p[:n])
+		r.md5.Write(p[:n])
+		r.crc32.Write(p[:n])
+		if room := r.keep - len(r.head); room > 0 {
+			r.head = append(r.head, p[:min(n, room)]...)
+		}
+	}
+	return n, err
+}
+
+// info returns the digests of the body read so far.
+func (r *digestReader) info() *bodyInfo {
+	info := &bodyInfo{
+		Size:        r.size,
+		SHA256:      hex.EncodeToString(r.sha256.Sum(nil)),
+		MD5:         hex.EncodeToString(r.md5.Sum(nil)),
+		CRC32:       hex.EncodeToString(r.crc32.Sum(nil)),
+		ContentType: r.contentType,
+	}
+	if len(r.head) > 0 {
+		info.DetectedType = http.DetectContentType(r.head[:min(len(r.head), sniffLen)])
+	}
+	return info
+}
+
+// digestRequested reports whether req asks for its body to be digested in the
+// response.
+func digestRequested(req *http.Request) bool {
+	_, ok := req.URL.Query()["digest"]
+	return ok && feature.Enabled("digest")
+}
+
+// bodyDigestKey is the context key of the digestReader of a request body.
+type bodyDigestKey struct{}
+
+// bodyDigest returns the digestReader of the request body, if it is being
+// digested.
+func bodyDigest(ctx context.Context) (*digestReader, bool) {
+	r, ok := ctx.Value(bodyDigestKey{}).(*digestReader)
+	return r, ok
+}
+
+// limitBody rejects requests with a body larger than maxBodySize, returning
+// false if it has responded with a 413. Bodies without a Content-Length are
+// cut off once they exceed the limit.
+func limitBody(wr http.ResponseWriter, req *http.Request) bool {
+	if maxBodySize <= 0 {
+		return true
+	}
+	if req.ContentLength > maxBodySize {
+		http.Error(wr, tooLarge(), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	req.Body = http.MaxBytesReader(wr, req.Body, maxBodySize)
+	return true
+}
+
+// isTooLarge reports whether err is from reading a body over maxBodySize.
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+func tooLarge() string {
+	return "request body larger than " + strconv.FormatInt(maxBodySize, 10) + " bytes"
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// capturedRequest is a request recorded in the inbox.
+type capturedRequest struct {
+	ID            uint64      `json:"id"`
+	Bin           string      `json:"bin"`
+	Time          time.Time   `json:"time"`
+	DurationMS    float64     `json:"durationMs"`
+	Remote        string      `json:"remote"`
+	Scheme        string      `json:"scheme"`
+	Proto         string      `json:"proto"`
+	Method        string      `json:"method"`
+	Host          string      `json:"host"`
+	URL           string      `json:"url"`
+	Path          string      `json:"path"`
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body"`
+	BodySize      int64       `json:"bodySize"`
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+	Status        int         `json:"status"`
+	TraceID       string      `json:"traceId,omitempty"`
+	SpanID        string      `json:"spanId,omitempty"`
+
+	ResponseHeaders       http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody          string      `json:"responseBody"`
+	ResponseSize          int64       `json:"responseSize"`
+	ResponseBodyTruncated bool        `json:"responseBodyTruncated,omitempty"`
+}
+
+// requestBin returns the bin of a request path, its first segment.
+func requestBin(path string) string {
+	bin, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return bin
+}
+
+// captureInbox is a ring buffer of the most recent requests, optionally
+// backed by a file of JSON lines so that it survives restarts.
+type captureInbox struct {
+	mu      sync.Mutex
+	size    int
+	entries []*capturedRequest // oldest first
+	nextID  uint64
+
+	path  string
+	file  *os.File
+	lines int // written to file, kept or evicted
+}
+
+var (
+	inbox        *captureInbox
+	inboxHandler http.Handler
+
+	// captureBodyLimit is how much of each request body is captured, set by
+	// CAPTURE_BODY_LIMIT.
+	captureBodyLimit = 64 << 10
+)
+
+// newCaptureInbox returns an inbox of the given size. If file is not empty,
+// requests are appended to it and the most recent are loaded from it. The
+// file is compacted to the requests kept once as many again have been evicted.
+func newCaptureInbox(size int, file string) (*captureInbox, error) {
+	in := &captureInbox{size: size, nextID: 1}
+	if file == "" {
+		return in, nil
+	}
+
+	if f, err := os.Open(file); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			var c capturedRequest
+			if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+				continue
+			}
+			in.append(&c)
+			in.nextID = c.ID + 1
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with only what was kept
+	if err := in.rewrite(file); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (in *captureInbox) append(c *capturedRequest) {
+	in.entries = append(in.entries, c)
+	if len(in.entries) > in.size {
+		in.entries = slices.Delete(in.entries, 0, len(in.entries)-in.size)
+	}
+}
+
+// rewrite replaces the file backing the inbox with the entries it holds,
+// writing them to a temporary file first so that none are lost on failure.
+func (in *captureInbox) rewrite(file string) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, c := range in.entries {
+		if err := enc.Encode(c); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if in.file != nil {
+		in.file.Close()
+	}
+	in.path, in.file = file, f
+	in.lines = len(in.entries)
+	return nil
+}
+
+// Add records c, assigning its ID.
+func (in *captureInbox) Add(c *capturedRequest) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	c.ID = in.nextID
+	in.nextID++
+	in.append(c)
+
+	if in.file == nil {
+		return
+	}
+	if in.lines >= 2*in.size {
+		if err := in.rewrite(in.path); err != nil {
+			slog.Warn("capture file", "error", err)
+		}
+		return
+	}
+	if err := json.NewEncoder(in.file).Encode(c); err != nil {
+		slog.Warn("capture file", "error", err)
+	}
+	in.lines++
+}
+
+// Get returns the request with the given ID.
+func (in *captureInbox) Get(id uint64) (*capturedRequest, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for _, c := range in.entries {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Find returns the requests matching the filter, newest first.
+func (in *captureInbox) Find(f captureFilter) []*capturedRequest {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	found := []*capturedRequest{}
+	for i := len(in.entries) - 1; i >= 0 && (f.limit <= 0 || len(found) < f.limit); i-- {
+		if c := in.entries[i]; f.match(c) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// Delete removes the requests matching the filter, returning how many were.
+func (in *captureInbox) Delete(f captureFilter) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	n := len(in.entries)
+	in.entries = slices.DeleteFunc(in.entries, f.match)
+	if in.file != nil {
+		if err := in.rewrite(in.path); err != nil {
+			slog.Warn("capture file", "error", err)
+		}
+	}
+	return n - len(in.entries)
+}
+
+// Bins returns the number of requests in each bin.
+func (in *captureInbox) Bins() map[string]int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	bins := map[string]int{}
+	for _, c := range in.entries {
+		bins[c.Bin]++
+	}
+	return bins
+}
+
+// captureFilter selects captured requests.
+type captureFilter struct {
+	bin      string
+	prefix   string
+	method   string
+	status   int
+	traceID  string
+	contains string
+	header   string // name, or name:value
+	since    time.Time
+	limit    int
+}
+
+// newCaptureFilter returns the filter given by the query parameters bin,
+// prefix, method, status, trace, contains, header, since and limit.
+func newCaptureFilter(req *http.Request) (captureFilter, error) {
+	query := req.URL.Query()
+	f := captureFilter{
+		bin:      query.Get("bin"),
+		prefix:   query.Get("prefix"),
+		method:   query.Get("method"),
+		traceID:  query.Get("trace"),
+		contains: query.Get("contains"),
+		header:   query.Get("header"),
+	}
+
+	var err error
+	if v := query.Get("status"); v != "" {
+		if f.status, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if f.limit, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		// either a time, or a period before now
+		if d, err := time.ParseDuration(v); err == nil {
+			f.since = time.Now().Add(-d)
+		} else if f.since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (f captureFilter) match(c *capturedRequest) bool {
+	if f.bin != "" && c.Bin != f.bin {
+		return false
+	}
+	if f.prefix != "" && !strings.HasPrefix(c.Path, f.prefix) {
+		return false
+	}
+	if f.method != "" && !strings.EqualFold(c.Method, f.method) {
+		return false
+	}
+	if f.status != 0 && c.Status != f.status {
+		return false
+	}
+	if f.traceID != "" && c.TraceID != f.traceID {
+		return false
+	}
+	if f.contains != "" && !strings.Contains(c.Body, f.contains) {
+		return false
+	}
+	if f.header != "" {
+		name, value, hasValue := strings.Cut(f.header, ":")
+		values := c.Headers.Values(name)
+		if len(values) == 0 || (hasValue && !slices.Contains(values, strings.TrimSpace(value))) {
+			return false
+		}
+	}
+	if !f.since.IsZero() && c.Time.Before(f.since) {
+		return false
+	}
+	return true
+}
+
+// capture records a request as it is served.
+type capture struct {
+	req    *http.Request
+	start  time.Time
+	status int
+	body   *captureReader
+
+	header   http.Header // of the response, as it is written
+	respSize int64
+	respHead []byte
+}
+
+// captureReader keeps the first bytes of a body as it is read.
+type captureReader struct {
+	io.ReadCloser
+	limit int
+	size  int64
+	head  []byte
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if room := r.limit - len(r.head); room > 0 && n > 0 {
+		r.head = append(r.head, p[:min(n, room)]...)
+	}
+	return n, err
+}
+
+// startCapture starts recording req, returning the writer to respond with.
+func startCapture(wr http.ResponseWriter, req *http.Request) (*capture, http.ResponseWriter) {
+	c := &capture{req: req, start: time.Now()}
+	header := wr.Header()
+	started := func(code int) {
+		if c.status == 0 {
+			c.status = code
+			c.header = header.Clone()
+		}
+	}
+	wr = httpsnoop.Wrap(wr, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				started(code)
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				started(http.StatusOK)
+				n, err := next(b)
+				c.recordResponse(b[:n])
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			// io.Copy writes through ReadFrom when it can, bypassing Write
+			return func(src io.Reader) (int64, error) {
+				started(http.StatusOK)
+				return next(io.TeeReader(src, responseRecorder{c}))
+			}
+		},
+	})
+	return c, wr
+}
+
+// recordResponse records b as written to the response body.
+func (c *capture) recordResponse(b []byte) {
+	c.respSize += int64(len(b))
+	if room := captureBodyLimit - len(c.respHead); room > 0 && len(b) > 0 {
+		c.respHead = append(c.respHead, b[:min(len(b), room)]...)
+	}
+}
+
+// responseRecorder records what is written to it as written to the response
+// body of a capture.
+type responseRecorder struct {
+	c *capture
+}
+
+func (r responseRecorder) Write(b []byte) (int, error) {
+	r.c.recordResponse(b)
+	return len(b), nil
+}
+
+// captureBody records the body of req as it is read, as it was received before
+// any decoding, so that it can be replayed with its Content-Encoding.
+func (c *capture) captureBody(req *http.Request, limit int) {
+	c.body = &captureReader{ReadCloser: req.Body, limit: limit}
+	req.Body = c.body
+}
+
+// finish adds the request to the inbox.
+func (c *capture) finish() {
+	req := c.req
+	entry := &capturedRequest{
+		Bin:        requestBin(req.URL.Path),
+		Time:       c.start,
+		DurationMS: milliseconds(time.Since(c.start)),
+		Remote:     req.RemoteAddr,
+		Scheme:     "http",
+		Proto:      req.Proto,
+		Method:     req.Method,
+		Host:       req.Host,
+		URL:        req.URL.String(),
+		Path:       req.URL.Path,
+		Headers:    req.Header.Clone(),
+		Status:     c.status,
+
+		ResponseHeaders:       c.header,
+		ResponseBody:          string(c.respHead),
+		ResponseSize:          c.respSize,
+		ResponseBodyTruncated: c.respSize > int64(len(c.respHead)),
+	}
+	if req.TLS != nil {
+		entry.Scheme = "https"
+	}
+
+	if b := c.body; b != nil {
+		// read what the handler left unread, and a byte more to tell if the
+		// body is truncated
+		if remaining := b.limit - len(b.head); remaining >= 0 {
+			io.CopyN(io.Discard, b, int64(remaining)+1)
+		}
+		entry.Body = string(b.head)
+		entry.BodySize = max(b.size, req.ContentLength)
+		entry.BodyTruncated = entry.BodySize > int64(len(b.head))
+	}
+
+	if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+		entry.TraceID = sc.TraceID().String()
+		entry.SpanID = sc.SpanID().String()
+	}
+
+	inbox.Add(entry)
+}
+
+// newInboxHandler returns the handler of the inbox API and viewer.
+//
+//	GET    /.requests       list captured requests, newest first
+//	DELETE /.requests       delete captured requests
+//	GET    /.requests.har   export captured requests as HAR
+//	GET    /.requests/bins  count the captured requests in each bin
+//	GET    /.requests/{id}  get a captured request
+//	GET    /.inbox          view captured requests
+func newInboxHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.requests", listCaptured)
+	mux.HandleFunc("DELETE /.requests", deleteCaptured)
+	mux.HandleFunc("GET /.requests.har", exportHAR)
+	mux.HandleFunc("GET /.requests/bins", func(wr http.ResponseWriter, req *http.Request) {
+		writeJSON(wr, http.StatusOK, inbox.Bins())
+	})
+	mux.HandleFunc("GET /.requests/{id}", getCaptured)
+	mux.HandleFunc("GET /.inbox", func(wr http.ResponseWriter, req *http.Request) {
+		wr.Header().Add("Content-Type", "text/html")
+		wr.WriteHeader(200)
+		io.WriteString(wr, inboxHTML)
+	})
+	return mux
+}
+
+func listCaptured(wr http.ResponseWriter, req *http.Request) {
+	f, err := newCaptureFilter(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(wr, http.StatusOK, inbox.Find(f))
+}
+
+func deleteCaptured(wr http.ResponseWriter, req *http.Request) {
+	f, err := newCaptureFilter(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(wr, http.StatusOK, map[string]int{"deleted": inbox.Delete(f)})
+}
+
+func getCaptured(wr http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(wr, req)
+		return
+	}
+	c, ok := inbox.Get(id)
+	if !ok {
+		http.NotFound(wr, req)
+		return
+	}
+	writeJSON(wr, http.StatusOK, c)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Chain is the body of a chained request. Each hop calls the first URL of the
+// chain, passing on the rest, and calls every branch of the fanout
+// concurrently, each passing on its own chain and fanout. The hop adds the
+// members of Baggage to the baggage of its calls.
+type Chain struct {
+	URL     []string          `json:"chain"`
+	Fanout  []Branch          `json:"fanout,omitempty"`
+	Baggage map[string]string `json:"baggage,omitempty"`
+}
+
+// Branch is a downstream call made by a hop, with its own think, delay and
+// timeout which apply as their query parameters do to the hop.
+type Branch struct {
+	Name    string `json:"name,omitempty"`
+	URL     string `json:"url"`
+	Think   string `json:"think,omitempty"`
+	Delay   string `json:"delay,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	Chain
+}
+
+// branches returns the calls to be made by this hop, the next link of the
+// linear chain (if any) followed by the fanout.
+func (c Chain) branches() []Branch {
+	var branches []Branch
+	if len(c.URL) > 0 {
+		branches = append(branches, Branch{
+			URL:   c.URL[0],
+			Chain: Chain{URL: c.URL[1:]},
+		})
+	}
+	for i, b := range c.Fanout {
+		if b.Name == "" {
+			b.Name = strconv.Itoa(i)
+		}
+		branches = append(branches, b)
+	}
+	return branches
+}
+
+// chainResult records the outcome of calling the next link in a chain. Its
+// duration covers the call alone, excluding the branch's think and delay.
+type chainResult struct {
+	Branch     string  `json:"branch,omitempty"`
+	URL        string  `json:"url"`
+	Status     int     `json:"status,omitempty"`
+	DurationMS float64 `json:"durationMs"`
+	Host       string  `json:"host,omitempty"`
+	TraceID    string  `json:"traceId,omitempty"`
+	SpanID     string  `json:"spanId,omitempty"`
+	Think      string  `json:"think,omitempty"`
+	Delay      string  `json:"delay,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	// Propagated lists the propagated headers sent on the call, as
+	// matched by PROPAGATE_HEADERS.
+	Propagated map[string]string `json:"propagated,omitempty"`
+	Response   any               `json:"response,omitempty"`
+
+	body []byte
+}
+
+// chainResultKey is the context key of the chainResult for an outgoing call.
+type chainResultKey struct{}
+
+// spanCapture is wrapped by the otelhttp transport to record the client span
+// of each outgoing call in its chainResult.
+type spanCapture struct {
+	http.RoundTripper
+}
+
+func (t spanCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	if result, ok := req.Context().Value(chainResultKey{}).(*chainResult); ok {
+		if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+			result.TraceID = sc.TraceID().String()
+			result.SpanID = sc.SpanID().String()
+		}
+		// the tracer has injected its headers by now
+		result.Propagated = propagatedHeaders(req.Header)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// summary describes the call in a single line.
+func (c *chainResult) summary() string {
+	var b strings.Builder
+	b.WriteString(c.URL)
+	if c.Status != 0 {
+		fmt.Fprintf(&b, " | %d", c.Status)
+	}
+	fmt.Fprintf(&b, " | %.3fms", c.DurationMS)
+	if c.Host != "" {
+		fmt.Fprintf(&b, " | served by %s", c.Host)
+	}
+	if c.TraceID != "" {
+		fmt.Fprintf(&b, " | trace %s span %s", c.TraceID, c.SpanID)
+	}
+	if len(c.Propagated) > 0 {
+		names := make([]string, 0, len(c.Propagated))
+		for name := range c.Propagated {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, " | propagated %s", strings.Join(names, ", "))
+	}
+	return b.String()
+}
+
+// setBody stores the downstream response body, embedding it as JSON when the
+// next link answered in the json format so the result nests cleanly.
+func (c *chainResult) setBody(body []byte) {
+	c.body = body
+	if json.Valid(body) {
+		c.Response = json.RawMessage(body)
+	} else {
+		c.Response = string(body)
+	}
+}
+
+// maxChainSize is the largest body parsed for a chain.
+const maxChainSize = 1 << 20
+
+func servePOST(wr http.ResponseWriter, req *http.Request) {
+	// Example
+	// curl -XPOST http://localhost:8080/?headers -d '{ "chain": ["http://localhost:8080/?headers&think=300ms&delay=300ms", "http://localhost:8080/?headers&think=150ms&delay=2000ms"]}'
+	// curl -XPOST http://localhost:8080/?headers -d '{ "fanout": [{"url": "http://localhost:8080/?headers", "think": "300ms"}, {"url": "http://localhost:8080/?headers", "chain": ["http://localhost:8080/?delay=2000ms"]}]}'
+	// replace & with ^& on windos
+
+	// inspect and parse body for a chain, reading no more than a chain could
+	// be so that large bodies are not buffered
+	reqBody, err := io.ReadAll(io.LimitReader(req.Body, maxChainSize+1))
+	if isTooLarge(err) {
+		http.Error(wr, tooLarge(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	chain := Chain{}
+	if len(reqBody) <= maxChainSize {
+		err = json.Unmarshal([]byte(reqBody), &chain)
+		if err != nil {
+			slog.WarnContext(req.Context(), "invalid chain", "error", err)
+		}
+	}
+
+	branches := chain.branches()
+	if len(branches) == 0 {
+		// no chain so act like get, echoing the body already consumed
+		// followed by the rest
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(reqBody), req.Body))
+		serveGET(wr, req, true)
+		return
+	}
+
+	resp := newEchoResponse(req)
+
+	// default timeout for post request
+	timeout := 60 * time.Second
+
+	// override timeout if allowed and provided
+	timeoutS, ok := queryParam(req, "timeout")
+	if ok && feature.Enabled("timeout") {
+		if d, err := time.ParseDuration(timeoutS); err == nil {
+			timeout = d
+		}
+	}
+
+	// think delay before chain link if requested
+	resp.Think = sleepFor(req, "think")
+
+	// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
+
+	ctx := req.Context()
+	if len(chain.Baggage) > 0 {
+		if !feature.Enabled("otel") {
+			// nothing extracts the baggage of the request without otel, so
+			// it is parsed here for the chain to add to
+			bag, _ := baggage.Parse(req.Header.Get("baggage"))
+			ctx = baggage.ContextWithBaggage(ctx, bag)
+		}
+		ctx = withBaggage(ctx, chain.Baggage)
+	}
+	// tr := otel.Tracer("echo-server/client")
+	tr := trace.SpanFromContext(ctx).TracerProvider().Tracer("echo-server/client")
+
+	httptr := &http.Transport{
+		// disable tls checks on http post calls
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	transport := otelhttp.NewTransport(
+		spanCapture{httptr},
+		otelhttp.WithClientTrace(func(ctx context.Context) *httptrace.ClientTrace {
+			return otelhttptrace.NewClientTrace(ctx)
+		}))
+
+	results := make([]*chainResult, len(branches))
+	func(ctx context.Context) {
+		ctx, span := tr.Start(ctx, "invoke_chain", trace.WithAttributes(semconv.ProcessCommand("echo-server")))
+		defer span.End()
+
+		var wg sync.WaitGroup
+		for i, b := range branches {
+			wg.Add(1)
+			go func(i int, b Branch) {
+				defer wg.Done()
+				results[i] = callBranch(ctx, tr, req, transport, b, timeout)
+			}(i, b)
+		}
+		wg.Wait()
+	}(ctx)
+
+	if len(chain.URL) > 0 {
+		resp.Chain, results = results[0], results[1:]
+	}
+	resp.Fanout = results
+
+	// delay response if requested
+	resp.Delay = sleepFor(req, "delay")
+
+	writeEchoResponse(wr, req, resp, bytes.NewReader(reqBody))
+}
+
+// callBranch calls the URL of b, passing on the rest of its chain. Fanout
+// branches are called in their own span so that their think and delay show
+// alongside the downstream call.
+func callBranch(
+	ctx context.Context,
+	tr trace.Tracer,
+	src *http.Request,
+	transport http.RoundTripper,
+	b Branch,
+	timeout time.Duration,
+) *chainResult {
+	result := &chainResult{
+		Branch: b.Name,
+		URL:    b.URL,
+	}
+
+	defer func() {
+		outcome := "success"
+		if result.Error != "" {
+			outcome = "error"
+		}
+		chainCalls.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("outcome", outcome),
+			attribute.Int("http.response.status_code", result.Status),
+		))
+		slog.InfoContext(ctx, "chain call",
+			"branch", result.Branch,
+			"url", result.URL,
+			"status", result.Status,
+			"durationMs", result.DurationMS,
+			"host", result.Host,
+			"error", result.Error,
+		)
+	}()
+
+	if b.Name != "" {
+		var span trace.Span
+		ctx, span = tr.Start(ctx, "invoke_branch", trace.WithAttributes(
+			attribute.String("echo.branch", b.Name),
+			semconv.URLFull(b.URL),
+		))
+		defer span.End()
+	}
+
+	if d, err := time.ParseDuration(b.Timeout); err == nil && feature.Enabled("timeout") {
+		timeout = d
+	}
+
+	if d, err := time.ParseDuration(b.Think); err == nil && feature.Enabled("think") {
+		time.Sleep(d)
+		recordDelay(ctx, "think", d)
+		result.Think = b.Think
+	}
+
+	chainBody, _ := json.Marshal(b.Chain)
+	ctx = context.WithValue(ctx, chainResultKey{}, result)
+	postReq, err := http.NewRequestWithContext(ctx, "POST", b.URL, bytes.NewReader(chainBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// propagate tracing headers
+	// https://istio.io/latest/about/faq/distributed-tracing/#how-to-support-tracing
+
+	// create new outgoing trace and inject into outgoing request
+	//		ctx, postReq = otelhttptrace.W3C(ctx, postReq)
+	//		otelhttptrace.Inject(ctx, postReq)
+
+	_, postReq = PropagateEfxHeaders(ctx, src, postReq)
+	if !feature.Enabled("otel") {
+		// the global propagator is a no-op without otel, so baggage added by
+		// the chain is injected directly
+		propagation.Baggage{}.Inject(ctx, propagation.HeaderCarrier(postReq.Header))
+	}
+
+	// call next link in chain, asking for the same format we respond with
+	postReq.Header.Set("Content-Type", "application/json")
+	postReq.Header.Set("Accept", contentType(responseFormat(src)))
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	start := time.Now()
+	resp, err := client.Do(postReq)
+	if err != nil {
+		result.DurationMS = milliseconds(time.Since(start))
+		result.Error = err.Error()
+		return result
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close() // think otel requires close
+	result.DurationMS = milliseconds(time.Since(start))
+	result.Status = resp.StatusCode
+	result.Host = resp.Header.Get("X-Echo-Host")
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.setBody(body)
+
+	if d, err := time.ParseDuration(b.Delay); err == nil && feature.Enabled("delay") {
+		time.Sleep(d)
+		recordDelay(ctx, "delay", d)
+		result.Delay = b.Delay
+	}
+
+	return result
+}
+
+// milliseconds returns d as fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodings lists the supported content codings, in order of preference when
+// a client accepts several equally.
+var encodings = []string{"br", "zstd", "gzip", "deflate"}
+
+// encoder is implemented by the compressing writers of every coding.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(coding string, w io.Writer) encoder {
+	switch coding {
+	case "br":
+		return brotli.NewWriter(w)
+	case "zstd":
+		enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		return enc
+	case "gzip":
+		return gzip.NewWriter(w)
+	case "deflate":
+		return zlib.NewWriter(w)
+	}
+	return nil
+}
+
+// errUnsupportedEncoding is returned for a content coding that cannot be
+// decoded.
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+func newDecoder(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(coding) {
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	case "identity":
+		return io.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, coding)
+}
+
+// compressWriter compresses a response once its header is written, unless it
+// has no body or is already encoded.
+type compressWriter struct {
+	http.ResponseWriter
+
+	coding      string
+	enc         encoder
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", w.coding)
+		h.Del("Content-Length")
+		w.enc = newEncoder(w.coding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.enc.Write(data)
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close completes the compressed response.
+func (w *compressWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+	return w.enc.Close()
+}
+
+// compress decodes the request body as given by its Content-Encoding, and
+// returns the writer to respond with, compressed with the coding negotiated by
+// the Accept-Encoding header. The compress query parameter forces a coding,
+// or with none disables compression. The returned func completes the response.
+//
+// It returns false if it has responded with an error as the request body
+// could not be decoded.
+func compress(wr http.ResponseWriter, req *http.Request) (http.ResponseWriter, func(), bool) {
+	var decoders []io.Closer
+	done := func() {
+		for _, dec := range decoders {
+			dec.Close()
+		}
+	}
+
+	if ce := req.Header.Get("Content-Encoding"); ce != "" && req.Body != nil {
+		body := io.Reader(req.Body)
+		codings := strings.Split(ce, ",")
+		// codings are listed in the order they were applied
+		for i := len(codings) - 1; i >= 0; i-- {
+			dec, err := newDecoder(strings.TrimSpace(codings[i]), body)
+			if err != nil {
+				done()
+				code := http.StatusBadRequest
+				if errors.Is(err, errUnsupportedEncoding) {
+					code = http.StatusUnsupportedMediaType
+				} else if isTooLarge(err) {
+					code = http.StatusRequestEntityTooLarge
+				}
+				http.Error(wr, err.Error(), code)
+				return nil, nil, false
+			}
+			decoders = append(decoders, dec)
+			body = dec
+		}
+
+		// the limit applies to the decoded body too
+		rc := io.NopCloser(body)
+		if maxBodySize > 0 {
+			rc = http.MaxBytesReader(wr, rc, maxBodySize)
+		}
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{rc, req.Body}
+		req.ContentLength = -1
+	}
+
+	wr.Header().Add("Vary", "Accept-Encoding")
+
+	coding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if v := req.URL.Query().Get("compress"); v != "" {
+		for _, c := range encodings {
+			if strings.EqualFold(v, c) {
+				coding = c
+			}
+		}
+		if strings.EqualFold(v, "none") || strings.EqualFold(v, "identity") {
+			coding = ""
+		}
+	}
+
+	if coding == "" || req.Method == http.MethodHead {
+		return wr, done, true
+	}
+
+	cw := &compressWriter{ResponseWriter: wr, coding: coding}
+	return cw, func() {
+		cw.Close()
+		done()
+	}, true
+}
+
+// negotiateEncoding returns the supported coding most preferred by an
+// Accept-Encoding header, or "" for none.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	q := map[string]float64{}
+	for _, item := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(coding)] = weight
+	}
+
+	for _, coding := range encodings {
+		weight, ok := q[coding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// faultWriter injects faults into a response as it is written.
+type faultWriter struct {
+	http.ResponseWriter
+
+	status  int   // status code replacing the handler's, if non-zero
+	abort   int64 // bytes to write before aborting, if non-negative
+	written int64
+}
+
+func (w *faultWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		code = w.status
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *faultWriter) Write(data []byte) (int, error) {
+	if w.abort < 0 {
+		return w.ResponseWriter.Write(data)
+	}
+
+	remaining := w.abort - w.written
+	if int64(len(data)) <= remaining {
+		n, err := w.ResponseWriter.Write(data)
+		w.written += int64(n)
+		return n, err
+	}
+
+	w.ResponseWriter.Write(data[:remaining])
+	http.NewResponseController(w.ResponseWriter).Flush()
+
+	// abandon the response, which closes the connection (or resets the
+	// stream over HTTP/2) without completing it
+	panic(http.ErrAbortHandler)
+}
+
+func (w *faultWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *faultWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// injectFault applies the faults requested by req, returning the writer to
+// respond with. It returns false if the connection has been reset, in which
+// case there is nothing left to respond to.
+//
+// Faults are requested with the status, abort and reset query parameters, or
+// the equivalent X-Echo-Status, X-Echo-Abort and X-Echo-Reset headers. Each
+// takes an optional probability of the fault being injected, e.g.
+// ?status=503:0.2 responds with a 503 to 20% of requests. A reset takes no
+// value, so its probability is given as ?reset=:0.2.
+func injectFault(wr http.ResponseWriter, req *http.Request) (http.ResponseWriter, bool) {
+	if spec, ok := faultParam(req, "reset"); ok {
+		if v, inject := parseFault(spec); v == "" && inject {
+			recordFault(req.Context(), "reset")
+			resetConnection(wr, req)
+			return nil, false
+		}
+	}
+
+	fw := &faultWriter{ResponseWriter: wr, abort: -1}
+	var applied []string
+
+	if spec, ok := faultParam(req, "status"); ok {
+		if v, inject := parseFault(spec); inject {
+			if code, err := strconv.Atoi(v); err == nil && code >= 100 && code <= 999 {
+				fw.status = code
+				applied = append(applied, "status="+v)
+				recordFault(req.Context(), "status")
+			}
+		}
+	}
+
+	if spec, ok := faultParam(req, "abort"); ok {
+		if v, inject := parseFault(spec); inject {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+				fw.abort = n
+				applied = append(applied, "abort="+v)
+				recordFault(req.Context(), "abort")
+			}
+		}
+	}
+
+	if len(applied) == 0 {
+		return wr, true
+	}
+
+	wr.Header().Set("X-Echo-Fault", strings.Join(applied, ", "))
+	return fw, true
+}
+
+// faultParam returns the named fault spec from the query, or else from its
+// X-Echo- header, or else its default.
+func faultParam(req *http.Request, name string) (string, bool) {
+	if values, ok := req.URL.Query()[name]; ok {
+		return values[0], true
+	}
+	if values, ok := req.Header[http.CanonicalHeaderKey("X-Echo-"+name)]; ok {
+		return values[0], true
+	}
+	return paramDefault(name)
+}
+
+// parseFault splits a value[:probability] spec, reporting whether the fault
+// should be injected into this request.
+func parseFault(spec string) (string, bool) {
+	value, prob, _ := strings.Cut(spec, ":")
+	return value, chance(prob)
+}
+
+// checkFault checks the spec of the named fault, as the admin API takes it for
+// a default, which injectFault would otherwise ignore on every request.
+func checkFault(name, spec string) error {
+	value, prob, hasProb := strings.Cut(spec, ":")
+	if hasProb {
+		if p, err := strconv.ParseFloat(prob, 64); err != nil || p < 0 || p > 1 {
+			return fmt.Errorf("invalid %s probability %q, must be from 0 to 1", name, prob)
+		}
+	}
+
+	switch name {
+	case "status":
+		if code, err := strconv.Atoi(value); err != nil || code < 100 || code > 999 {
+			return fmt.Errorf("invalid status %q, must be from 100 to 999", value)
+		}
+	case "abort":
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 0 {
+			return fmt.Errorf("invalid abort %q, must be a number of bytes", value)
+		}
+	case "reset":
+		if value != "" {
+			return fmt.Errorf("invalid reset %q, takes only a probability, e.g. :0.5", value)
+		}
+	}
+	return nil
+}
+
+// chance reports whether an event with the given probability occurs, where an
+// empty probability is certain.
+func chance(prob string) bool {
+	if prob == "" {
+		return true
+	}
+	p, err := strconv.ParseFloat(prob, 64)
+	if err != nil {
+		return false
+	}
+	return rand.Float64() < p
+}
+
+// resetConnection closes the client connection abruptly. HTTP/1 connections
+// are closed with a TCP RST, whereas an HTTP/2 stream is reset.
+func resetConnection(wr http.ResponseWriter, req *http.Request) {
+	conn, _, err := http.NewResponseController(wr).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		// skip the close_notify alert
+		conn = tc.NetConn()
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	if err := conn.Close(); err != nil {
+		slog.WarnContext(req.Context(), "reset", "remote", req.RemoteAddr, "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// featureSet records which features are enabled. It is safe for concurrent
+// use, as features can be toggled at runtime through the admin API.
+type featureSet struct {
+	mu      sync.RWMutex
+	enabled map[string]bool
+}
+
+func newFeatureSet() *featureSet {
+	return &featureSet{enabled: map[string]bool{}}
+}
+
+// Enabled reports whether the named feature is enabled.
+func (f *featureSet) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.enabled[name]
+}
+
+// Set enables or disables the named feature.
+func (f *featureSet) Set(name string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled[name] = enabled
+}
+
+// Known reports whether name is a feature, whether or not it is enabled.
+func (f *featureSet) Known(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, ok := f.enabled[name]
+	return ok
+}
+
+// All returns a copy of the state of every feature.
+func (f *featureSet) All() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	all := make(map[string]bool, len(f.enabled))
+	for name, enabled := range f.enabled {
+		all[name] = enabled
+	}
+	return all
+}
+
+// runtimeFeatures are the features that can be toggled at runtime through the
+// admin API. The rest are only read as the server starts.
+var runtimeFeatures = []string{
+	"delay", "think", "headers", "env", "meta", "log", "post", "timeout",
+	"fault", "stream", "digest", "form", "compress", "capture", "tracecontext",
+	"sse", "payload",
+}
+
+// isRuntimeFeature reports whether name is a feature that can be toggled at
+// runtime.
+func isRuntimeFeature(name string) bool {
+	return slices.Contains(runtimeFeatures, name)
+}
+
+// defaultParams are the query parameters that can be given a default value,
+// used when a request does not specify its own.
+var defaultParams = []string{"delay", "think", "timeout", "status", "abort", "reset"}
+
+// paramDefaults holds the defaults of query parameters, which are set
+// through the admin API.
+var paramDefaults = struct {
+	sync.RWMutex
+	values map[string]string
+}{
+	values: map[string]string{},
+}
+
+// queryParam returns the named query parameter of req, or else its default.
+func queryParam(req *http.Request, name string) (string, bool) {
+	if values, ok := req.URL.Query()[name]; ok {
+		return values[0], true
+	}
+	return paramDefault(name)
+}
+
+func paramDefault(name string) (string, bool) {
+	paramDefaults.RLock()
+	defer paramDefaults.RUnlock()
+	v, ok := paramDefaults.values[name]
+	return v, ok
+}
+
+func setParamDefault(name, value string) {
+	paramDefaults.Lock()
+	defer paramDefaults.Unlock()
+	paramDefaults.values[name] = value
+}
+
+func clearParamDefault(name string) {
+	paramDefaults.Lock()
+	defer paramDefaults.Unlock()
+	delete(paramDefaults.values, name)
+}
+
+func allParamDefaults() map[string]string {
+	paramDefaults.RLock()
+	defer paramDefaults.RUnlock()
+
+	all := make(map[string]string, len(paramDefaults.values))
+	for name, value := range paramDefaults.values {
+		all[name] = value
+	}
+	return all
+}
+
+// isDefaultParam reports whether name is a parameter that takes a default.
+func isDefaultParam(name string) bool {
+	return slices.Contains(defaultParams, name)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maxFormValue is the most of a form value, or of a URL encoded form, that is
+// reported. File parts are digested whatever their size.
+const maxFormValue = 64 << 10
+
+// formInfo lists the fields and files of a form body.
+type formInfo struct {
+	Fields map[string][]string `json:"fields,omitempty"`
+	Files  []*formFile         `json:"files,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// formFile describes a file part of a multipart form.
+type formFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// formRequested reports whether req asks for its form to be parsed in the
+// response.
+func formRequested(req *http.Request) bool {
+	_, ok := req.URL.Query()["form"]
+	return ok && feature.Enabled("form")
+}
+
+// parseForm parses a URL encoded or multipart form body of the given content
+// type from r, returning nil if it is not a form. File parts are digested as
+// they are read rather than buffered.
+func parseForm(contentType string, r io.Reader) *formInfo {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	form := &formInfo{Fields: map[string][]string{}}
+	switch mt {
+	case "application/x-www-form-urlencoded":
+		data, err := io.ReadAll(io.LimitReader(r, maxFormValue))
+		if err != nil {
+			form.Error = err.Error()
+			return form
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			form.Error = err.Error()
+		}
+		form.Fields = values
+	case "multipart/form-data":
+		if err := parseMultipart(form, multipart.NewReader(r, params["boundary"])); err != nil {
+			form.Error = err.Error()
+		}
+	default:
+		return nil
+	}
+	return form
+}
+
+func parseMultipart(form *formInfo, mr *multipart.Reader) error {
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValue))
+			if err != nil {
+				return err
+			}
+			form.Fields[part.FormName()] = append(form.Fields[part.FormName()], string(value))
+			continue
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, part)
+		if err != nil {
+			return err
+		}
+		form.Files = append(form.Files, &formFile{
+			Field:       part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        n,
+			SHA256:      hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+}
+
+// summary returns a one line summary of the form.
+func (f *formInfo) summary() string {
+	s := fmt.Sprintf("%d fields | %d files", len(f.Fields), len(f.Files))
+	if f.Error != "" {
+		s += " | error " + f.Error
+	}
+	return s
+}
+
+// writeForm writes the fields and files of the form as text.
+func writeForm(wr io.Writer, f *formInfo) {
+	names := make([]string, 0, len(f.Fields))
+	for name := range f.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range f.Fields[name] {
+			fmt.Fprintf(wr, "%s: %s\n", name, strings.ReplaceAll(value, "\n", "\\n"))
+		}
+	}
+
+	for _, file := range f.Files {
+		fmt.Fprintf(wr, "%s: file %q", file.Field, file.Filename)
+		if file.ContentType != "" {
+			fmt.Fprintf(wr, " (%s)", file.ContentType)
+		}
+		fmt.Fprintf(wr, " %d bytes | sha256 %s\n", file.Size, file.SHA256)
+	}
+}
@@ -291,3 +291,162 @@ var websocketHTML = `
     </body>
 </html>
 `
+
+var inboxHTML = `
+<html>
+    <head>
+        <title>inbox</title>
+    </head>
+    <style>
+    body {
+        font-family: sans-serif;
+    }
+
+    table {
+        border-collapse: collapse;
+        width: 100%;
+    }
+
+    th, td {
+        text-align: left;
+        padding: 0.3em 0.6em;
+        border-bottom: 1px dashed lightgray;
+        font-family: monospace;
+        vertical-align: top;
+    }
+
+    tr.entry {
+        cursor: pointer;
+    }
+
+    tr.entry:hover {
+        background: #f4f4f4;
+    }
+
+    pre {
+        margin: 0;
+        white-space: pre-wrap;
+        word-break: break-all;
+    }
+
+    .hidden {
+        display: none;
+    }
+
+    .error {
+        color: red;
+    }
+
+    input, button {
+        border-radius: 0.3em;
+        border: 1px solid lightgray;
+        background: white;
+        padding: 0.2em 0.4em;
+    }
+    </style>
+    <body>
+        <form id="filter">
+            <input name="bin" placeholder="bin">
+            <input name="prefix" placeholder="path prefix">
+            <input name="method" placeholder="method" size="8">
+            <input name="status" placeholder="status" size="6">
+            <input name="contains" placeholder="body contains">
+            <input name="header" placeholder="header[:value]">
+            <input name="trace" placeholder="trace ID">
+            <input name="since" placeholder="since, e.g. 5m" size="10">
+            <button type="submit">Filter</button>
+            <button type="button" id="clear">Delete matching</button>
+            <label><input type="checkbox" id="live" checked> live</label>
+        </form>
+        <p id="status"></p>
+        <table>
+            <thead>
+                <tr><th>#</th><th>time</th><th>bin</th><th>method</th><th>url</th><th>status</th><th>body</th><th>remote</th><th>trace</th></tr>
+            </thead>
+            <tbody id="requests"></tbody>
+        </table>
+    </body>
+    <script>
+    const form = document.getElementById("filter");
+    const rows = document.getElementById("requests");
+    const status = document.getElementById("status");
+    const live = document.getElementById("live");
+    const open = new Set();
+
+    function query() {
+        const params = new URLSearchParams();
+        for (const [k, v] of new FormData(form)) {
+            if (v) params.set(k, v);
+        }
+        return params.toString();
+    }
+
+    function cell(tr, text) {
+        const td = document.createElement("td");
+        td.textContent = text;
+        tr.appendChild(td);
+    }
+
+    function render(requests) {
+        rows.replaceChildren();
+        for (const r of requests) {
+            const tr = document.createElement("tr");
+            tr.className = "entry";
+            cell(tr, r.id);
+            cell(tr, new Date(r.time).toLocaleTimeString());
+            cell(tr, r.bin);
+            cell(tr, r.method);
+            cell(tr, r.url);
+            cell(tr, r.status);
+            cell(tr, r.bodySize + (r.bodyTruncated ? " (truncated)" : ""));
+            cell(tr, r.remote);
+            cell(tr, r.traceId || "");
+
+            const details = document.createElement("tr");
+            details.className = open.has(r.id) ? "" : "hidden";
+            const td = document.createElement("td");
+            td.colSpan = 9;
+            const pre = document.createElement("pre");
+            const headers = Object.entries(r.headers || {}).map(([k, vs]) => vs.map(v => k + ": " + v).join("\n")).join("\n");
+            pre.textContent = r.proto + " " + r.method + " " + r.url + "\nHost: " + r.host + "\n" + headers + "\n\n" + r.body;
+            td.appendChild(pre);
+            details.appendChild(td);
+
+            tr.onclick = () => {
+                details.classList.toggle("hidden");
+                open.has(r.id) ? open.delete(r.id) : open.add(r.id);
+            };
+            rows.appendChild(tr);
+            rows.appendChild(details);
+        }
+    }
+
+    async function refresh() {
+        try {
+            const resp = await fetch("/.requests?limit=200&" + query());
+            if (!resp.ok) throw new Error(await resp.text());
+            const requests = await resp.json();
+            render(requests);
+            status.className = "";
+            status.textContent = requests.length + " requests, updated " + new Date().toLocaleTimeString();
+        } catch (e) {
+            status.className = "error";
+            status.textContent = e;
+        }
+    }
+
+    form.onsubmit = (e) => {
+        e.preventDefault();
+        refresh();
+    };
+
+    document.getElementById("clear").onclick = async () => {
+        await fetch("/.requests?" + query(), { method: "DELETE" });
+        refresh();
+    };
+
+    setInterval(() => live.checked && refresh(), 2000);
+    refresh();
+    </script>
+</html>
+`
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcHandler serves gRPC requests received on the main port, if enabled.
+var grpcHandler *grpc.Server
+
+// The Echo service takes and returns google.protobuf.Struct messages, so that
+// it needs no generated code. Its descriptor is built here instead of being
+// compiled from a .proto file:
+//
+//	service Echo {
+//	  rpc Echo(google.protobuf.Struct) returns (google.protobuf.Struct);
+//	  rpc ServerStream(google.protobuf.Struct) returns (stream google.protobuf.Struct);
+//	  rpc ClientStream(stream google.protobuf.Struct) returns (google.protobuf.Struct);
+//	  rpc Bidi(stream google.protobuf.Struct) returns (stream google.protobuf.Struct);
+//	}
+const (
+	echoProtoFile   = "echo/v1/echo.proto"
+	echoServiceName = "echo.v1.Echo"
+)
+
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: echoServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Echo", Handler: echoUnaryHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ServerStream", Handler: echoServerStream, ServerStreams: true},
+		{StreamName: "ClientStream", Handler: echoClientStream, ClientStreams: true},
+		{StreamName: "Bidi", Handler: echoBidi, ServerStreams: true, ClientStreams: true},
+	},
+	Metadata: echoProtoFile,
+}
+
+// registerEchoDescriptor registers the descriptor of the Echo service, so
+// that it is available to server reflection.
+func registerEchoDescriptor() error {
+	if _, err := protoregistry.GlobalFiles.FindFileByPath(echoProtoFile); err == nil {
+		return nil
+	}
+
+	method := func(name string, clientStreams, serverStreams bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".google.protobuf.Struct"),
+			OutputType:      proto.String(".google.protobuf.Struct"),
+			ClientStreaming: proto.Bool(clientStreams),
+			ServerStreaming: proto.Bool(serverStreams),
+		}
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String(echoProtoFile),
+		Package:    proto.String("echo.v1"),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Syntax:     proto.String("proto3"),
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Echo", false, false),
+				method("ServerStream", false, true),
+				method("ClientStream", true, false),
+				method("Bidi", true, true),
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		return err
+	}
+	return protoregistry.GlobalFiles.RegisterFile(fd)
+}
+
+// newGRPCServer returns a gRPC server with the Echo, health and reflection
+// services registered. It is traced by otelgrpc if instrument is true, which
+// is not needed when it serves requests already traced by otelhttp.
+func newGRPCServer(instrument bool) (*grpc.Server, error) {
+	if err := registerEchoDescriptor(); err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if instrument {
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&echoServiceDesc, struct{}{})
+	healthpb.RegisterHealthServer(srv, grpcHealthServer)
+	reflection.Register(srv)
+	return srv, nil
+}
+
+// isGRPC reports whether req is a gRPC request.
+func isGRPC(req *http.Request) bool {
+	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// stopGRPC stops srv gracefully, forcing it to stop once ctx is done.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+func echoUnaryHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		if err := grpcPrologue(ctx); err != nil {
+			return nil, err
+		}
+		return grpcEcho(ctx, map[string]any{"request": req})
+	}
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + echoServiceName + "/Echo",
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// echoServerStream echoes the request x-echo-count times (3 by default),
+// waiting x-echo-interval between each.
+func echoServerStream(_ any, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	if err := grpcPrologue(ctx); err != nil {
+		return err
+	}
+
+	in := new(structpb.Struct)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	count := 3
+	if v, ok := grpcParam(ctx, "count"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			count = n
+		}
+	}
+
+	var interval time.Duration
+	if v, ok := grpcParam(ctx, "interval"); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			interval = d
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if err := grpcSleep(ctx, interval); err != nil {
+				return err
+			}
+		}
+
+		out, err := grpcEcho(ctx, map[string]any{"request": in, "index": i})
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// echoClientStream echoes every request once the client closes the stream.
+func echoClientStream(_ any, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	if err := grpcPrologue(ctx); err != nil {
+		return err
+	}
+
+	var requests []any
+	for {
+		in := new(structpb.Struct)
+		err := stream.RecvMsg(in)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		requests = append(requests, in)
+	}
+
+	out, err := grpcEcho(ctx, map[string]any{"requests": requests})
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(out)
+}
+
+// echoBidi echoes each request as it is received.
+func echoBidi(_ any, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	if err := grpcPrologue(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		in := new(structpb.Struct)
+		err := stream.RecvMsg(in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		out, err := grpcEcho(ctx, map[string]any{"request": in, "index": i})
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(out); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcPrologue injects the delay and faults requested by the x-echo-delay,
+// x-echo-status, x-echo-abort and x-echo-reset metadata, or their defaults, as
+// the query parameters do for HTTP requests. The status is a gRPC code name or
+// number, or an HTTP status mapped to its gRPC code, e.g. UNAVAILABLE:0.2 fails
+// 20% of calls. A reset fails the call as UNAVAILABLE and an abort as INTERNAL,
+// as a client sees a dropped connection and a reset stream.
+func grpcPrologue(ctx context.Context) error {
+	_, log := grpcParam(ctx, "log")
+	if os.Getenv("LOG_ALL") != "" || (log && feature.Enabled("log")) {
+		method, _ := grpc.Method(ctx)
+		slog.InfoContext(ctx, "grpc request", "method", method, "remote", grpcPeer(ctx))
+	}
+
+	if value, ok := grpcParam(ctx, "delay"); ok && feature.Enabled("delay") {
+		if d, err := time.ParseDuration(value); err == nil {
+			if err := grpcSleep(ctx, d); err != nil {
+				return err
+			}
+			recordDelay(ctx, "delay", d)
+		}
+	}
+
+	if !feature.Enabled("fault") {
+		return nil
+	}
+
+	if spec, ok := grpcParam(ctx, "reset"); ok {
+		if v, inject := parseFault(spec); v == "" && inject {
+			recordFault(ctx, "reset")
+			return status.Error(codes.Unavailable, "injected fault reset")
+		}
+	}
+
+	if spec, ok := grpcParam(ctx, "status"); ok {
+		if v, inject := parseFault(spec); inject {
+			code, ok := parseCode(v)
+			if ok && code != codes.OK {
+				recordFault(ctx, "status")
+				return status.Errorf(code, "injected fault status=%s", v)
+			}
+		}
+	}
+
+	if spec, ok := grpcParam(ctx, "abort"); ok {
+		if v, inject := parseFault(spec); inject {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+				recordFault(ctx, "abort")
+				return status.Errorf(codes.Internal, "injected fault abort=%s", v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// grpcSleep sleeps for d, unless the deadline of the call passes first.
+func grpcSleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// parseCode parses a gRPC status code given by its name or number, or an HTTP
+// status, which is mapped to the code a gRPC client reports on receiving it.
+// https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func parseCode(v string) (codes.Code, bool) {
+	if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+		if n < 100 {
+			return codes.Code(n), true
+		}
+		return httpStatusCode(int(n)), n <= 999
+	}
+
+	var code codes.Code
+	if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(v)))); err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// httpStatusCode returns the gRPC code of an HTTP status.
+func httpStatusCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	if status < 400 {
+		return codes.OK
+	}
+	return codes.Unknown
+}
+
+// grpcParam returns the value of the x-echo-<name> metadata of the call, or
+// else its default.
+func grpcParam(ctx context.Context, name string) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("x-echo-" + name); len(values) > 0 {
+		return values[0], true
+	}
+	return paramDefault(name)
+}
+
+func grpcPeer(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// grpcEcho returns the details of the call, as reported by serveGET for HTTP
+// requests, along with the given fields. The metadata, environment and meta
+// data are included when the x-echo-headers, x-echo-env and x-echo-meta
+// metadata are present and the features enabled.
+func grpcEcho(ctx context.Context, fields map[string]any) (*structpb.Struct, error) {
+	method, _ := grpc.Method(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	resp := &echoResponse{
+		Time:   time.Now(),
+		Proto:  "gRPC",
+		Method: method[strings.LastIndex(method, "/")+1:],
+		URL:    method,
+	}
+	host, err := os.Hostname()
+	if err == nil {
+		resp.Host = host
+	} else {
+		resp.HostError = err.Error()
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			resp.TLS = newTLSInfo(&info.State)
+		}
+	}
+
+	if _, ok := grpcParam(ctx, "headers"); ok && feature.Enabled("headers") {
+		resp.Headers = http.Header(md.Copy())
+	}
+	if _, ok := grpcParam(ctx, "env"); ok && feature.Enabled("env") {
+		resp.Env = os.Environ()
+	}
+	if _, ok := grpcParam(ctx, "meta"); ok && feature.Enabled("meta") {
+		resp.Meta = meta
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs("x-echo-host", resp.Host))
+
+	// convert through JSON, which is how the Struct is presented anyway
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	for k, v := range fields {
+		m[k] = v
+	}
+
+	return toStruct(m)
+}
+
+// toStruct converts m to a Struct, allowing it to hold Struct values.
+func toStruct(m map[string]any) (*structpb.Struct, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out := new(structpb.Struct)
+	if err := out.UnmarshalJSON(data); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcHealthInterval is how often Watch re-evaluates the status of a service,
+// as the ready probe can change without notice, e.g. when its file appears.
+const grpcHealthInterval = time.Second
+
+// grpcHealth implements grpc.health.v1.Health. Every service is serving while
+// the ready probe passes, unless it has been set down. The server as a whole,
+// the empty service name, follows the ready probe alone.
+type grpcHealth struct {
+	healthpb.UnimplementedHealthServer
+
+	mu       sync.Mutex
+	services map[string]bool
+	changed  chan struct{} // closed and replaced when a service is set
+}
+
+var grpcHealthServer = &grpcHealth{
+	services: map[string]bool{echoServiceName: true},
+	changed:  make(chan struct{}),
+}
+
+// Set sets the named service up or down, adding it if it is not known.
+func (h *grpcHealth) Set(service string, up bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.services[service] = up
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Status returns the status of the named service, and whether it is known.
+func (h *grpcHealth) Status(service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	h.mu.Lock()
+	up, ok := h.services[service]
+	h.mu.Unlock()
+
+	if service == "" {
+		up, ok = true, true
+	}
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if !up || readyProbe.Check() != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+// States returns the status of every known service.
+func (h *grpcHealth) States() map[string]string {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.services)+1)
+	names = append(names, "")
+	for name := range h.services {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+
+	states := make(map[string]string, len(names))
+	for _, name := range names {
+		s, _ := h.Status(name)
+		states[name] = s.String()
+	}
+	return states
+}
+
+func (h *grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s, ok := h.Status(req.GetService())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: s}, nil
+}
+
+// Watch streams the status of the service whenever it changes, starting with
+// its current status.
+func (h *grpcHealth) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(grpcHealthInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		h.mu.Lock()
+		changed := h.changed
+		h.mu.Unlock()
+
+		if s, _ := h.Status(req.GetService()); s != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: s}); err != nil {
+				return err
+			}
+			last = s
+		}
+
+		select {
+		case <-ticker.C:
+		case <-changed:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// har is an HTTP Archive, as described by
+// http://www.softwareishard.com/blog/har-12-spec/. Only what echo-server
+// records is included.
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harPostData is the body of a request. The spec has no encoding for binary
+// bodies, so the custom _encoding field is set to base64 for them, as the
+// encoding of harContent is.
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harText returns body as HAR text, base64 encoded if it is not UTF-8.
+func harText(body string) (string, string) {
+	if utf8.ValidString(body) {
+		return body, ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(body)), "base64"
+}
+
+// decodeHARText returns the body of HAR text.
+func decodeHARText(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nvs := []harNameValue{}
+	for _, name := range names {
+		for _, value := range h[name] {
+			nvs = append(nvs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return nvs
+}
+
+// newHAR returns the captured requests as an HTTP Archive, oldest first.
+func newHAR(captured []*capturedRequest) *har {
+	h := &har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "echo-server", Version: buildVersion()},
+		Entries: []harEntry{},
+	}}
+
+	for i := len(captured) - 1; i >= 0; i-- {
+		h.Log.Entries = append(h.Log.Entries, newHAREntry(captured[i]))
+	}
+	return h
+}
+
+func newHAREntry(c *capturedRequest) harEntry {
+	u := &url.URL{Scheme: c.Scheme, Host: c.Host}
+	if ref, err := url.Parse(c.URL); err == nil {
+		u = u.ResolveReference(ref)
+	}
+
+	query := []harNameValue{}
+	for name, values := range u.Query() {
+		for _, value := range values {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.Slice(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+
+	entry := harEntry{
+		StartedDateTime: c.Time,
+		Time:            c.DurationMS,
+		Request: harRequest{
+			Method:      c.Method,
+			URL:         u.String(),
+			HTTPVersion: c.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(c.Headers),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    c.BodySize,
+		},
+		Response: harResponse{
+			Status:      c.Status,
+			StatusText:  http.StatusText(c.Status),
+			HTTPVersion: c.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(c.ResponseHeaders),
+			Content: harContent{
+				Size:     c.ResponseSize,
+				MimeType: c.ResponseHeaders.Get("Content-Type"),
+			},
+			RedirectURL: c.ResponseHeaders.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    c.ResponseSize,
+		},
+		Timings: harTimings{Wait: c.DurationMS},
+	}
+
+	if c.BodySize > 0 {
+		text, encoding := harText(c.Body)
+		entry.Request.PostData = &harPostData{
+			MimeType: c.Headers.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		}
+	}
+	entry.Response.Content.Text, entry.Response.Content.Encoding = harText(c.ResponseBody)
+
+	var truncated []string
+	if c.BodyTruncated {
+		truncated = append(truncated, "request")
+	}
+	if c.ResponseBodyTruncated {
+		truncated = append(truncated, "response")
+	}
+	if len(truncated) > 0 {
+		entry.Comment = strings.Join(truncated, " and ") + " body truncated"
+	}
+	return entry
+}
+
+// exportHAR responds with the captured requests matching the query filters as
+// an HTTP Archive.
+func exportHAR(wr http.ResponseWriter, req *http.Request) {
+	f, err := newCaptureFilter(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wr.Header().Set("Content-Disposition", `attachment; filename="requests.har"`)
+	writeJSON(wr, http.StatusOK, newHAR(inbox.Find(f)))
+}
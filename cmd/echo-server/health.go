@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// probe is a health check whose state can be controlled, so as to test how
+// load balancers and orchestrators react to it failing.
+//
+// A probe fails when it has been set down, through the admin API or a signal,
+// while its file exists, and periodically when it is set to flap.
+type probe struct {
+	name string
+	file string // fails while this file exists
+
+	// fails for flapDown at the end of every flap period
+	flap     time.Duration
+	flapDown time.Duration
+
+	// fails until the startup delay has elapsed
+	waitStartup bool
+
+	mu sync.Mutex
+	up bool
+}
+
+var (
+	startTime    = time.Now()
+	startupDelay time.Duration
+	shuttingDown atomic.Bool
+
+	liveProbe    = &probe{name: "live"}
+	readyProbe   = &probe{name: "ready", waitStartup: true}
+	startupProbe = &probe{name: "startup", waitStartup: true}
+
+	probes = map[string]*probe{
+		liveProbe.name:    liveProbe,
+		readyProbe.name:   readyProbe,
+		startupProbe.name: startupProbe,
+	}
+)
+
+// setupHealth configures the probes from the environment. Each probe is
+// configured by HEALTH_<PROBE>_FILE, HEALTH_<PROBE>_FLAP and
+// HEALTH_<PROBE>_FLAP_DOWN, and HEALTH_STARTUP_DELAY delays the startup and
+// ready probes passing.
+func setupHealth() {
+	startupDelay = getenvDuration("HEALTH_STARTUP_DELAY", 0)
+
+	for name, p := range probes {
+		prefix := "HEALTH_" + strings.ToUpper(name)
+		p.up = true
+		p.file = os.Getenv(prefix + "_FILE")
+		p.flap = getenvDuration(prefix+"_FLAP", 0)
+		p.flapDown = getenvDuration(prefix+"_FLAP_DOWN", 30*time.Second)
+	}
+}
+
+// Set sets the probe up or down.
+func (p *probe) Set(up bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.up = up
+}
+
+// Toggle sets the probe down if it is up and vice versa, returning whether it
+// is now up.
+func (p *probe) Toggle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.up = !p.up
+	return p.up
+}
+
+// Check returns an error describing why the probe is failing, if it is.
+func (p *probe) Check() error {
+	p.mu.Lock()
+	up := p.up
+	p.mu.Unlock()
+
+	if !up {
+		return errors.New("set down")
+	}
+
+	elapsed := time.Since(startTime)
+	if p.waitStartup && elapsed < startupDelay {
+		return fmt.Errorf("starting up for another %s", (startupDelay - elapsed).Round(time.Millisecond))
+	}
+
+	if p == readyProbe && shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+
+	if p.file != "" {
+		if _, err := os.Stat(p.file); err == nil {
+			return fmt.Errorf("%s exists", p.file)
+		}
+	}
+
+	if p.flap > 0 {
+		phase := (elapsed - startupDelay) % p.flap
+		if phase >= p.flap-p.flapDown {
+			return fmt.Errorf("flapping down for another %s", (p.flap - phase).Round(time.Millisecond))
+		}
+	}
+
+	return nil
+}
+
+// serveHealth responds to a probe request for /.health/{probe}.
+func serveHealth(wr http.ResponseWriter, req *http.Request) {
+	p, ok := probes[strings.TrimPrefix(req.URL.Path, "/.health/")]
+	if !ok {
+		http.NotFound(wr, req)
+		return
+	}
+
+	wr.Header().Set("Content-Type", "text/plain")
+	if err := p.Check(); err != nil {
+		wr.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(wr, "%s: %s\n", p.name, err)
+		return
+	}
+	wr.WriteHeader(http.StatusOK)
+	fmt.Fprintf(wr, "%s: ok\n", p.name)
+}
+
+// healthStates returns the state of every probe, with the reason for any that
+// are failing.
+func healthStates() map[string]string {
+	states := make(map[string]string, len(probes))
+	for name, p := range probes {
+		if err := p.Check(); err != nil {
+			states[name] = err.Error()
+		} else {
+			states[name] = "ok"
+		}
+	}
+	return states
+}
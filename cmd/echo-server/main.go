@@ -1,37 +1,38 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
+	"net"
 	"net/http"
-	"net/http/httptrace"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp/filters"
 	"go.opentelemetry.io/otel"
-	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
 
 var meta string
-var feature map[string]bool
+var feature *featureSet
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -48,25 +49,96 @@ func main() {
 
 	logInit()
 
+	if v := os.Getenv("MAX_BODY_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			slog.Error("MAX_BODY_SIZE", "error", err)
+			return
+		}
+		maxBodySize = n
+	}
+
 	// setup features
 	setupFeatures()
 
+	// setup health probes
+	setupHealth()
+
+	// setup request capture
+	captureSize := 1000
+	if v := os.Getenv("CAPTURE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			slog.Error("CAPTURE_SIZE", "value", v)
+			return
+		}
+		captureSize = n
+	}
+	if v := os.Getenv("CAPTURE_BODY_LIMIT"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			slog.Error("CAPTURE_BODY_LIMIT", "error", err)
+			return
+		}
+		captureBodyLimit = int(n)
+	}
+	var err error
+	if inbox, err = newCaptureInbox(captureSize, os.Getenv("CAPTURE_FILE")); err != nil {
+		slog.Error("newCaptureInbox", "error", err)
+		return
+	}
+	inboxHandler = newInboxHandler()
+
+	// setup header propagation
+	if v := os.Getenv("PROPAGATE_HEADERS"); v != "" {
+		propagateHeaders = parseHeaderMatcher(v)
+		slog.Info("propagating headers", "headers", propagateHeaders)
+	}
+
+	// load mock routes
+	if file := os.Getenv("ROUTES_FILE"); file != "" {
+		mux, err := loadRoutes(file)
+		if err != nil {
+			slog.Error("loadRoutes", "error", err)
+			return
+		}
+		routes = mux
+	}
+
 	ctx := context.Background()
-	if !feature["nosignals"] {
+	if !feature.Enabled("nosignals") {
 		ctx = signalContext()
+		notifyHealthSignals()
 	}
 
-	// setup handler
-	handl := h2c.NewHandler(
-		http.HandlerFunc(handler),
-		&http2.Server{},
-	)
+	// setup metrics
+	var metricReaders []sdkmetric.Reader
+	if feature.Enabled("metrics") {
+		reader, h, err := newPrometheusReader()
+		if err != nil {
+			slog.Error("newPrometheusReader", "error", err)
+			return
+		}
+		metricReaders = append(metricReaders, reader)
+		metricsHandler = h
+	}
 
-	if feature["otel"] {
+	// setup handler
+	var echoHandler http.Handler = http.HandlerFunc(handler)
+	if feature.Enabled("metrics") {
+		echoHandler = measureRequests(echoHandler)
+	}
+	// the h2c handler takes over the connections of HTTP/2 clients, which the
+	// servers then no longer track, so they are tracked for the drain
+	h2s := &http2.Server{}
+	handl := trackH2C(h2c.NewHandler(
+		echoHandler,
+		h2s,
+	))
+
+	if feature.Enabled("otel") {
 		// Set up OpenTelemetry.
-		serviceName := "echo-server"
-		serviceVersion := "0.0.1"
-		otelShutdown, err := setupOTelSDK(ctx, serviceName, serviceVersion)
+		otelShutdown, err := setupOTelSDK(ctx, metricReaders...)
 		if err != nil {
 			slog.Error("setupOTelSDK", "error", err)
 			return
@@ -77,17 +149,123 @@ func main() {
 		}()
 
 		var opts []otelhttp.Option
-		if !feature["traceoptions"] {
+		if !feature.Enabled("traceoptions") {
 			opts = append(opts, otelhttp.WithFilter(filters.Not(filters.Method("OPTIONS"))))
 		}
 		handl = otelhttp.NewHandler(handl, "", opts...)
+	} else if len(metricReaders) > 0 {
+		meterShutdown := setupMeterProvider(metricReaders...)
+		defer meterShutdown(context.Background())
 	}
+	initMetrics()
 
-	err := http.ListenAndServe(":"+port, handl)
-	if err != nil {
-		panic(err)
+	if feature.Enabled("grpc") {
+		srv, err := newGRPCServer(false)
+		if err != nil {
+			slog.Error("newGRPCServer", "error", err)
+			return
+		}
+		grpcHandler = srv
+	}
+
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		srv, err := newGRPCServer(feature.Enabled("otel"))
+		if err != nil {
+			slog.Error("newGRPCServer", "error", err)
+			return
+		}
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			slog.Error("listen", "port", grpcPort, "error", err)
+			return
+		}
+		fmt.Printf("gRPC server listening on port %s.\n", grpcPort)
+		go srv.Serve(lis)
+		shutdownHooks = append(shutdownHooks, func(ctx context.Context) {
+			stopGRPC(ctx, srv)
+		})
+	}
+
+	servers := []*http.Server{{
+		Addr:    ":" + port,
+		Handler: handl,
+	}}
+	if err := configureHTTP2(servers[0], h2s); err != nil {
+		slog.Error("configureHTTP2", "error", err)
+		return
+	}
+
+	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" && metricsHandler != nil {
+		fmt.Printf("Metrics listening on port %s.\n", metricsPort)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler)
+		servers = append(servers, &http.Server{
+			Addr:    ":" + metricsPort,
+			Handler: mux,
+		})
+		metricsHandler = nil
+	}
+
+	if adminPort := os.Getenv("ADMIN_PORT"); adminPort != "" {
+		fmt.Printf("Admin API listening on port %s.\n", adminPort)
+		servers = append(servers, &http.Server{
+			Addr:    ":" + adminPort,
+			Handler: newAdminHandler(os.Getenv("ADMIN_TOKEN")),
+		})
+	}
+
+	if tlsPort := os.Getenv("TLS_PORT"); tlsPort != "" {
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			slog.Error("newTLSConfig", "error", err)
+			return
+		}
+		fmt.Printf("Echo server listening for TLS on port %s.\n", tlsPort)
+		srv := &http.Server{
+			Addr:      ":" + tlsPort,
+			Handler:   handl,
+			TLSConfig: tlsConfig,
+		}
+		if err := configureHTTP2(srv, h2s); err != nil {
+			slog.Error("configureHTTP2", "error", err)
+			return
+		}
+		servers = append(servers, srv)
+	}
+
+	for _, srv := range servers {
+		go listen(srv)
 	}
+
 	<-ctx.Done()
+	shutdownServers(servers...)
+}
+
+// configureHTTP2 configures srv to serve HTTP/2 over TLS with h2s, and to send
+// the connections of h2s, over h2c or TLS, a GOAWAY as it shuts down. A server
+// without TLS is left without, although ConfigureServer gives it a TLS config.
+func configureHTTP2(srv *http.Server, h2s *http2.Server) error {
+	plain := srv.TLSConfig == nil
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	if plain {
+		srv.TLSConfig = nil
+	}
+	return nil
+}
+
+// listen serves srv, using TLS if it has a TLS config.
+func listen(srv *http.Server) {
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
 }
 
 var upgrader = websocket.Upgrader{
@@ -99,18 +277,29 @@ var upgrader = websocket.Upgrader{
 func setupFeatures() {
 	// populates features map from environment variable
 	features := os.Getenv("ENABLE_FEATURES")
-	feature = make(map[string]bool)
-	feature["nosignals"] = ContainsI(features, "nosignals")
-	feature["delay"] = ContainsI(features, "delay")
-	feature["think"] = ContainsI(features, "think")
-	feature["headers"] = ContainsI(features, "headers")
-	feature["env"] = ContainsI(features, "env")
-	feature["meta"] = ContainsI(features, "meta")
-	feature["log"] = ContainsI(features, "log")
-	feature["otel"] = ContainsI(features, "otel")
-	feature["post"] = ContainsI(features, "post")
-	feature["timeout"] = ContainsI(features, "timeout")
-	feature["traceoptions"] = ContainsI(features, "traceoptions")
+	feature = newFeatureSet()
+	feature.Set("nosignals", ContainsI(features, "nosignals"))
+	feature.Set("delay", ContainsI(features, "delay"))
+	feature.Set("think", ContainsI(features, "think"))
+	feature.Set("headers", ContainsI(features, "headers"))
+	feature.Set("env", ContainsI(features, "env"))
+	feature.Set("meta", ContainsI(features, "meta"))
+	feature.Set("log", ContainsI(features, "log"))
+	feature.Set("otel", ContainsI(features, "otel"))
+	feature.Set("post", ContainsI(features, "post"))
+	feature.Set("timeout", ContainsI(features, "timeout"))
+	feature.Set("traceoptions", ContainsI(features, "traceoptions"))
+	feature.Set("fault", ContainsI(features, "fault"))
+	feature.Set("metrics", ContainsI(features, "metrics"))
+	feature.Set("grpc", ContainsI(features, "grpc"))
+	feature.Set("stream", ContainsI(features, "stream"))
+	feature.Set("digest", ContainsI(features, "digest"))
+	feature.Set("form", ContainsI(features, "form"))
+	feature.Set("compress", ContainsI(features, "compress"))
+	feature.Set("capture", ContainsI(features, "capture"))
+	feature.Set("tracecontext", ContainsI(features, "tracecontext"))
+	feature.Set("sse", ContainsI(features, "sse"))
+	feature.Set("payload", ContainsI(features, "payload"))
 }
 
 func ContainsI(a string, b string) bool {
@@ -120,36 +309,103 @@ func ContainsI(a string, b string) bool {
 	)
 }
 
+// getenvDuration returns the duration in the named environment variable, or
+// def if it is unset or invalid.
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		slog.Warn("invalid duration", "key", key, "value", v)
+	}
+	return def
+}
+
 func handler(wr http.ResponseWriter, req *http.Request) {
+	if !limitBody(wr, req) {
+		return
+	}
+
 	_, log := req.URL.Query()["log"]
-	if os.Getenv("LOG_HTTP_BODY") != "" {
-		fmt.Printf("--------  %s | %s %s\n", req.RemoteAddr, req.Method, req.URL)
-		buf := &bytes.Buffer{}
-		buf.ReadFrom(req.Body)
-
-		if buf.Len() != 0 {
-			w := hex.Dumper(os.Stdout)
-			w.Write(buf.Bytes())
-			w.Close()
+	logBody := os.Getenv("LOG_HTTP_BODY") != ""
+	if logBody || digestRequested(req) {
+		// digest the body as it is served, logging it once done
+		keep := 0
+		if logBody {
+			keep = logBodyLimit
 		}
+		d := newDigestReader(req, keep)
+		req.Body = d
+		req = req.WithContext(context.WithValue(req.Context(), bodyDigestKey{}, d))
+
+		defer func() {
+			if !logBody {
+				slog.InfoContext(req.Context(), "request body", "url", req.URL.String(), "body", d.info())
+				return
+			}
 
-		// Replace original body with buffered version so it's still sent to the
-		// browser.
-		req.Body.Close()
-		req.Body = io.NopCloser(
-			bytes.NewReader(buf.Bytes()),
+			// digest whatever was left unread
+			io.Copy(io.Discard, d)
+			slog.InfoContext(req.Context(), "request",
+				"remote", req.RemoteAddr,
+				"method", req.Method,
+				"url", req.URL.String(),
+				"body", d.info(),
+				"dump", hex.Dump(d.head),
+			)
+		}()
+	} else if os.Getenv("LOG_ALL") != "" || (log && feature.Enabled("log")) {
+		slog.InfoContext(req.Context(), "request",
+			"remote", req.RemoteAddr,
+			"method", req.Method,
+			"url", req.URL.String(),
 		)
-	} else if os.Getenv("LOG_ALL") != "" || (log && feature["log"]) {
-		fmt.Printf("%s | %s %s\n", req.RemoteAddr, req.Method, req.URL)
 	}
 
-	if websocket.IsWebSocketUpgrade(req) {
+	if grpcHandler != nil && isGRPC(req) {
+		grpcHandler.ServeHTTP(wr, req)
+	} else if websocket.IsWebSocketUpgrade(req) {
 		serveWebSocket(wr, req)
+	} else if req.URL.Path == "/metrics" && metricsHandler != nil {
+		metricsHandler.ServeHTTP(wr, req)
+	} else if strings.HasPrefix(req.URL.Path, "/.health/") {
+		serveHealth(wr, req)
+	} else if (strings.HasPrefix(req.URL.Path, "/.requests") || req.URL.Path == "/.inbox") && feature.Enabled("capture") {
+		inboxHandler.ServeHTTP(wr, req)
+	} else if req.URL.Path == "/.sse" && feature.Enabled("sse") {
+		serveSSE(wr, req)
+	} else if req.URL.Path == "/.payload" && feature.Enabled("payload") {
+		servePayload(wr, req)
+	} else if req.URL.Path == "/.upload" && feature.Enabled("payload") {
+		serveUpload(wr, req)
 	} else if req.URL.Path == "/.ws" {
 		wr.Header().Add("Content-Type", "text/html")
 		wr.WriteHeader(200)
 		io.WriteString(wr, websocketHTML)
 	} else {
+		var c *capture
+		if feature.Enabled("capture") {
+			c, wr = startCapture(wr, req)
+			c.captureBody(req, captureBodyLimit)
+			defer c.finish()
+		}
+		if feature.Enabled("fault") {
+			var ok bool
+			if wr, ok = injectFault(wr, req); !ok {
+				return
+			}
+		}
+		if feature.Enabled("compress") {
+			var done func()
+			var ok bool
+			if wr, done, ok = compress(wr, req); !ok {
+				return
+			}
+			defer done()
+		}
+		if serveRoute(wr, req) {
+			return
+		}
 		serveHTTP(wr, req)
 	}
 }
@@ -157,12 +413,13 @@ func handler(wr http.ResponseWriter, req *http.Request) {
 func serveWebSocket(wr http.ResponseWriter, req *http.Request) {
 	connection, err := upgrader.Upgrade(wr, req, nil)
 	if err != nil {
-		fmt.Printf("%s | %s\n", req.RemoteAddr, err)
+		slog.WarnContext(req.Context(), "websocket upgrade", "remote", req.RemoteAddr, "error", err)
 		return
 	}
 
 	defer connection.Close()
-	fmt.Printf("%s | upgraded to websocket\n", req.RemoteAddr)
+	defer trackWebSocket(connection)()
+	slog.InfoContext(req.Context(), "upgraded to websocket", "remote", req.RemoteAddr)
 
 	var message []byte
 
@@ -183,29 +440,31 @@ func serveWebSocket(wr http.ResponseWriter, req *http.Request) {
 				break
 			}
 
+			recordMessage(req.Context(), "received", messageType)
 			if messageType == websocket.TextMessage {
-				fmt.Printf("%s | txt | %s\n", req.RemoteAddr, message)
+				slog.InfoContext(req.Context(), "websocket message", "remote", req.RemoteAddr, "type", "txt", "message", string(message))
 			} else {
-				fmt.Printf("%s | bin | %d byte(s)\n", req.RemoteAddr, len(message))
+				slog.InfoContext(req.Context(), "websocket message", "remote", req.RemoteAddr, "type", "bin", "bytes", len(message))
 			}
 
 			err = connection.WriteMessage(messageType, message)
 			if err != nil {
 				break
 			}
+			recordMessage(req.Context(), "sent", messageType)
 		}
 	}
 
 	if err != nil {
-		fmt.Printf("%s | %s\n", req.RemoteAddr, err)
+		slog.InfoContext(req.Context(), "websocket closed", "remote", req.RemoteAddr, "error", err)
 	}
 }
 
 func serveHTTP(wr http.ResponseWriter, req *http.Request) {
-	if req.Method == "POST" && feature["post"] {
+	if req.Method == "POST" && feature.Enabled("post") {
 		servePOST(wr, req)
 	} else if req.Method == "OPTIONS" {
-		if feature["traceoptions"] {
+		if feature.Enabled("traceoptions") {
 			serveGET(wr, req, true)
 		} else {
 			serveGET(wr, req, false)
@@ -215,214 +474,24 @@ func serveHTTP(wr http.ResponseWriter, req *http.Request) {
 	}
 }
 
-type Chain struct {
-	URL []string `json:"chain"`
-}
-
-func servePOST(wr http.ResponseWriter, req *http.Request) {
-	// Example
-	// curl -XPOST http://localhost:8080/?headers -d '{ "chain": ["http://localhost:8080/?headers&think=300ms&delay=300ms", "http://localhost:8080/?headers&think=150ms&delay=2000ms"]}'
-	// replace & with ^& on windos
-
-	// inspect and parse body for a chain
-	reqBody, _ := io.ReadAll(req.Body)
-	chain := Chain{}
-	err := json.Unmarshal([]byte(reqBody), &chain)
-	if err != nil {
-		log.Printf("error: %v", err)
-	}
-
-	if len(chain.URL) == 0 {
-		// no chain so act like get
-		serveGET(wr, req, true)
-		return
-	}
-
-	// pop front of chain and prepare new chainBody
-	url := chain.URL[0]
-	chain.URL = chain.URL[1:]
-	chainBody, _ := json.Marshal(chain)
-
-	// output req
-	wr.Header().Add("Content-Type", "text/plain")
-	wr.WriteHeader(200)
-
-	host, err := os.Hostname()
-	if err == nil {
-		fmt.Fprintf(wr, "Request received by %s at %s\n\n", host, time.Now().Format(time.RFC3339Nano))
-	} else {
-		fmt.Fprintf(wr, "Server hostname unknown: %s\n\n", err.Error())
-	}
-
-	fmt.Fprintf(wr, "%s %s %s\n", req.Proto, req.Method, req.URL)
-	fmt.Fprintln(wr, "")
-
-	// output request headers if requested
-	if _, ok := req.URL.Query()["headers"]; ok && feature["headers"] {
-		fmt.Fprintf(wr, "Host: %s\n", req.Host)
-		for key, values := range req.Header {
-			for _, value := range values {
-				fmt.Fprintf(wr, "%s: %s\n", key, value)
-			}
-		}
-		fmt.Fprintln(wr, "")
-	}
-
-	fmt.Fprintf(wr, "%s\n", reqBody)
-	fmt.Fprintln(wr, "")
-
-	// default timeout for post request
-	timeout := 60 * time.Second
-
-	// override timeout if allowed and provided
-	timeoutS := req.URL.Query()["timeout"]
-	if len(timeoutS) > 0 && feature["timeout"] {
-		if d, err := time.ParseDuration(timeoutS[0]); err == nil {
-			timeout = d
-		}
-	}
-
-	// think delay before chain link if requested
-	delays := req.URL.Query()["think"]
-	if len(delays) > 0 && feature["think"] {
-		if d, err := time.ParseDuration(delays[0]); err == nil {
-			time.Sleep(d)
-			fmt.Fprintf(wr, "Thinking for: %s\n\n", delays[0])
-		}
-	}
-
-	// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
-
-	ctx := req.Context()
-	// tr := otel.Tracer("echo-server/client")
-	tr := trace.SpanFromContext(ctx).TracerProvider().Tracer("echo-server/client")
-
-	httptr := &http.Transport{
-		// disable tls checks on http post calls
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	//	client := &http.Client{Transport: htr}
-	client := &http.Client{Transport: otelhttp.NewTransport(
-		httptr,
-		otelhttp.WithClientTrace(func(ctx context.Context) *httptrace.ClientTrace {
-			return otelhttptrace.NewClientTrace(ctx)
-		})),
-		Timeout: timeout,
-	}
-
-	body, err := func(ctx context.Context) (body []byte, err error) {
-		ctx, span := tr.Start(ctx, "invoke_chain", trace.WithAttributes(semconv.ProcessCommand("echo-server")))
-		defer span.End()
-		postReq, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(chainBody))
-
-		// propagate tracing headers
-		// https://istio.io/latest/about/faq/distributed-tracing/#how-to-support-tracing
-
-		// create new outgoing trace and inject into outgoing request
-		//		ctx, postReq = otelhttptrace.W3C(ctx, postReq)
-		//		otelhttptrace.Inject(ctx, postReq)
-
-		_, postReq = PropagateEfxHeaders(ctx, req, postReq)
-
-		// call next link in chain
-		// resp, err := client.Post(url, "application/json", bytes.NewReader(chainBody))
-		postReq.Header.Set("Content-Type", "application/json")
-		resp, err := client.Do(postReq)
-		if err == nil {
-			body, _ = io.ReadAll(resp.Body)
-			_ = resp.Body.Close() // think otel requires close
-		}
-		return
-	}(ctx)
-
-	if err != nil {
-		fmt.Fprintf(wr, "chain call failed with %s\n", err)
-		fmt.Fprintln(wr, "")
-		return
-	}
-
-	// delay response if requested
-	delays = req.URL.Query()["delay"]
-	if len(delays) > 0 && feature["delay"] {
-		if d, err := time.ParseDuration(delays[0]); err == nil {
-			time.Sleep(d)
-			fmt.Fprintf(wr, "Delayed by: %s\n\n", delays[0])
-		}
-	}
-
-	wr.Write(body)
-}
-
 func serveGET(wr http.ResponseWriter, req *http.Request, startSpan bool) {
 	if startSpan {
-		ctx := req.Context()
 		tr := otel.Tracer("echo-server/server")
-		_, span := tr.Start(ctx, "serveGET", trace.WithAttributes(semconv.ProcessCommand("echo-server")))
+		ctx, span := tr.Start(req.Context(), "serveGET", trace.WithAttributes(semconv.ProcessCommand("echo-server")))
 		defer span.End()
+		req = req.WithContext(ctx)
 	}
 
-	wr.Header().Add("Content-Type", "text/plain")
-	wr.WriteHeader(200)
-
-	host, err := os.Hostname()
-	if err == nil {
-		fmt.Fprintf(wr, "Request received by %s at %s\n\n", host, time.Now().Format(time.RFC3339Nano))
-	} else {
-		fmt.Fprintf(wr, "Server hostname unknown: %s\n\n", err.Error())
+	// stream a generated response if requested
+	if _, ok := req.URL.Query()["stream"]; ok && feature.Enabled("stream") {
+		serveStream(wr, req)
+		return
 	}
 
-	fmt.Fprintf(wr, "%s %s %s\n", req.Proto, req.Method, req.URL)
-	fmt.Fprintln(wr, "")
+	resp := newEchoResponse(req)
 
 	// delay response if requested
-	delays := req.URL.Query()["delay"]
-	if len(delays) > 0 && feature["delay"] {
-		if d, err := time.ParseDuration(delays[0]); err == nil {
-			time.Sleep(d)
-			fmt.Fprintf(wr, "Delayed by: %s\n\n", delays[0])
-		}
-	}
-
-	// output request headers if requested
-	if _, ok := req.URL.Query()["headers"]; ok && feature["headers"] {
-		fmt.Fprintf(wr, "Host: %s\n", req.Host)
-		for key, values := range req.Header {
-			for _, value := range values {
-				fmt.Fprintf(wr, "%s: %s\n", key, value)
-			}
-		}
-		fmt.Fprintln(wr, "")
-	}
-
-	// dump environment if requested
-	if _, ok := req.URL.Query()["env"]; ok && feature["env"] {
-		for _, e := range os.Environ() {
-			fmt.Fprintf(wr, "%s\n", e)
-			//			pair := strings.SplitAfterN(e, "=", 2)
-			//			fmt.Fprintf(wr, "%s: %s\n", pair[0], pair[1])
-		}
-		fmt.Fprintln(wr, "")
-	}
-
-	// dump meta if requested
-	if _, ok := req.URL.Query()["meta"]; ok && feature["meta"] {
-		fmt.Fprintf(wr, "%s\n\n", meta)
-	}
-
-	io.Copy(wr, req.Body)
-}
-
-func PropagateEfxHeaders(ctx context.Context, src *http.Request, req *http.Request) (context.Context, *http.Request) {
-	// https://istio.io/latest/docs/tasks/observability/distributed-tracing/overview/#trace-context-propagation
-	efxHeaders := []string{"x-request-id"}
+	resp.Delay = sleepFor(req, "delay")
 
-	// for now we just copy from src to req, but in future we may have hierarchy and also look in ctx
-	for _, key := range efxHeaders {
-		if id := src.Header.Get(key); id != "" {
-			if req.Header.Get(key) == "" {
-				req.Header.Add(key, id)
-			}
-		}
-	}
-	return ctx, req
+	writeEchoResponse(wr, req, resp, req.Body)
 }
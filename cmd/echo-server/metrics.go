@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// metricsHandler serves the Prometheus metrics, if they are enabled.
+var metricsHandler http.Handler
+
+// The echo-server specific instruments. They are no-ops until initMetrics
+// creates them from the meter provider set up by setupOTelSDK or
+// setupMeterProvider.
+var (
+	requestCount      metric.Int64Counter       = noop.Int64Counter{}
+	requestDuration   metric.Float64Histogram   = noop.Float64Histogram{}
+	websocketConns    metric.Int64UpDownCounter = noop.Int64UpDownCounter{}
+	websocketMessages metric.Int64Counter       = noop.Int64Counter{}
+	chainCalls        metric.Int64Counter       = noop.Int64Counter{}
+	injectedDelay     metric.Float64Counter     = noop.Float64Counter{}
+	injectedFaults    metric.Int64Counter       = noop.Int64Counter{}
+)
+
+// initMetrics creates the instruments from the global meter provider.
+func initMetrics() {
+	meter := otel.Meter("echo-server")
+
+	requestCount, _ = meter.Int64Counter("echo.requests",
+		metric.WithDescription("Requests received."))
+	requestDuration, _ = meter.Float64Histogram("echo.request.duration",
+		metric.WithDescription("Duration of requests."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(
+			0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10, 30, 60))
+	websocketConns, _ = meter.Int64UpDownCounter("echo.websocket.connections",
+		metric.WithDescription("Open WebSocket connections."))
+	websocketMessages, _ = meter.Int64Counter("echo.websocket.messages",
+		metric.WithDescription("WebSocket messages received and sent."))
+	chainCalls, _ = meter.Int64Counter("echo.chain.calls",
+		metric.WithDescription("Calls made to the next link of a chain."))
+	injectedDelay, _ = meter.Float64Counter("echo.injected.delay",
+		metric.WithDescription("Total think and delay time injected."),
+		metric.WithUnit("s"))
+	injectedFaults, _ = meter.Int64Counter("echo.injected.faults",
+		metric.WithDescription("Faults injected into responses."))
+}
+
+// newPrometheusReader returns a metric reader that is scraped by Prometheus
+// through the returned handler.
+func newPrometheusReader() (sdkmetric.Reader, http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return exporter, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
+func newMeterProvider(res *resource.Resource, readers ...sdkmetric.Reader) *sdkmetric.MeterProvider {
+	opts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, r := range readers {
+		opts = append(opts, sdkmetric.WithReader(r))
+	}
+	return sdkmetric.NewMeterProvider(opts...)
+}
+
+// setupMeterProvider sets the global meter provider to one exporting to
+// readers, for when the OpenTelemetry SDK is not otherwise set up.
+func setupMeterProvider(readers ...sdkmetric.Reader) (shutdown func(context.Context) error) {
+	meterProvider := newMeterProvider(resource.Default(), readers...)
+	otel.SetMeterProvider(meterProvider)
+	return meterProvider.Shutdown
+}
+
+// measureRequests records the count and duration of requests to h.
+func measureRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		m := httpsnoop.CaptureMetrics(h, wr, req)
+
+		attrs := metric.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.Int("http.response.status_code", m.Code),
+			attribute.String("http.route", requestRoute(req)),
+		)
+		requestCount.Add(req.Context(), 1, attrs)
+		requestDuration.Record(req.Context(), m.Duration.Seconds(), attrs)
+	})
+}
+
+// requestRoute returns the route serving req, from a fixed set so that the
+// paths echoed do not each make a series: the special path, the pattern of
+// the mock route, or echo.
+func requestRoute(req *http.Request) string {
+	path := req.URL.Path
+	switch {
+	case grpcHandler != nil && isGRPC(req):
+		return "grpc"
+	case websocket.IsWebSocketUpgrade(req):
+		return "websocket"
+	case path == "/metrics", path == "/.ws", path == "/.inbox":
+		return path
+	case path == "/.sse" && feature.Enabled("sse"):
+		return path
+	case (path == "/.payload" || path == "/.upload") && feature.Enabled("payload"):
+		return path
+	case strings.HasPrefix(path, "/.health/"):
+		return "/.health/"
+	case strings.HasPrefix(path, "/.requests"):
+		return "/.requests"
+	}
+	if routes != nil {
+		if _, pattern := routes.Handler(req); pattern != "" {
+			return pattern
+		}
+	}
+	return "echo"
+}
+
+// recordDelay records a think or delay injected into a request.
+func recordDelay(ctx context.Context, kind string, d time.Duration) {
+	injectedDelay.Add(ctx, d.Seconds(), metric.WithAttributes(
+		attribute.String("kind", kind),
+	))
+}
+
+// recordMessage records a WebSocket message received or sent.
+func recordMessage(ctx context.Context, direction string, messageType int) {
+	kind := "binary"
+	if messageType == websocket.TextMessage {
+		kind = "text"
+	}
+	websocketMessages.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("direction", direction),
+		attribute.String("type", kind),
+	))
+}
+
+// recordFault records a fault injected into a response.
+func recordFault(ctx context.Context, fault string) {
+	injectedFaults.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("fault", fault),
+	))
+}
@@ -10,22 +10,32 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/contrib/bridges/otelslog"
 
 	// datadog "github.com/tonglil/opentelemetry-go-datadog-propagator"
 	"go.opentelemetry.io/contrib/propagators/autoprop"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shutdown func(context.Context) error, err error) {
+// Metrics are exported over OTLP, unless OTEL_METRICS_EXPORTER is none, and to
+// any readers given. Logs are also exported over OTLP if OTEL_LOGS_EXPORTER is
+// otlp.
+func setupOTelSDK(ctx context.Context, readers ...metric.Reader) (shutdown func(context.Context) error, err error) {
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -49,7 +59,7 @@ func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	otel.SetLogger(logr.FromSlogHandler(slog.Default().Handler()))
 
 	// Setup resource.
-	res, err := newResource(serviceName, serviceVersion)
+	res, err := newResource(ctx)
 	if err != nil {
 		handleErr(err)
 		return
@@ -68,15 +78,42 @@ func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
 	otel.SetTracerProvider(tracerProvider)
 
-	return
-}
+	// Setup meter provider.
+	if os.Getenv("OTEL_METRICS_EXPORTER") != "none" {
+		var metricReader metric.Reader
+		metricReader, err = newMetricReader(ctx)
+		if err != nil {
+			handleErr(err)
+			return
+		}
+		readers = append(readers, metricReader)
+	}
+	if len(readers) > 0 {
+		meterProvider := newMeterProvider(res, readers...)
+		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+		otel.SetMeterProvider(meterProvider)
+	}
+
+	// Setup logger provider.
+	if os.Getenv("OTEL_LOGS_EXPORTER") == "otlp" {
+		var loggerProvider *sdklog.LoggerProvider
+		loggerProvider, err = newLoggerProvider(ctx, res)
+		if err != nil {
+			handleErr(err)
+			return
+		}
+		shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
+		global.SetLoggerProvider(loggerProvider)
+
+		// Bridge slog to OpenTelemetry, keeping the local output.
+		bridge := otelslog.NewHandler("echo-server", otelslog.WithLoggerProvider(loggerProvider))
+		slog.SetDefault(slog.New(teeHandler{
+			slog.Default().Handler(),
+			leveledHandler{bridge, logLevel},
+		}))
+	}
 
-func newResource(serviceName, serviceVersion string) (*resource.Resource, error) {
-	return resource.Merge(resource.Default(),
-		resource.NewWithAttributes(semconv.SchemaURL,
-			semconv.ServiceName(serviceName),
-			semconv.ServiceVersion(serviceVersion),
-		))
+	return
 }
 
 func newPropagator() propagation.TextMapPropagator {
@@ -101,6 +138,10 @@ func newPropagator() propagation.TextMapPropagator {
 }
 
 func newTraceProvider(ctx context.Context, res *resource.Resource) (traceProvider *trace.TracerProvider, err error) {
+	if err := checkSampler(); err != nil {
+		return nil, err
+	}
+
 	var traceExporter *otlptrace.Exporter
 	if os.Getenv("TRACE_GRPC") != "" {
 		traceExporter, err = otlptracegrpc.New(ctx)
@@ -116,7 +157,43 @@ func newTraceProvider(ctx context.Context, res *resource.Resource) (traceProvide
 		trace.WithBatcher(traceExporter, trace.WithBatchTimeout(time.Second)),
 		trace.WithResource(res),
 		// https://opentelemetry.io/docs/languages/go/sampling/
-		// trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(1.1))
+		// the sampler is read from OTEL_TRACES_SAMPLER by the SDK
 	)
 	return traceProvider, nil
 }
+
+func newMetricReader(ctx context.Context) (metric.Reader, error) {
+	var metricExporter metric.Exporter
+	var err error
+	if os.Getenv("METRICS_GRPC") != "" {
+		metricExporter, err = otlpmetricgrpc.New(ctx)
+	} else {
+		metricExporter, err = otlpmetrichttp.New(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The export interval defaults to 1m, and is set by
+	// OTEL_METRIC_EXPORT_INTERVAL.
+	return metric.NewPeriodicReader(metricExporter), nil
+}
+
+func newLoggerProvider(ctx context.Context, res *resource.Resource) (*sdklog.LoggerProvider, error) {
+	var logExporter sdklog.Exporter
+	var err error
+	if os.Getenv("LOGS_GRPC") != "" {
+		logExporter, err = otlploggrpc.New(ctx)
+	} else {
+		logExporter, err = otlploghttp.New(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loggerProvider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
+		sdklog.WithResource(res),
+	)
+	return loggerProvider, nil
+}
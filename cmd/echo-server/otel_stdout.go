@@ -13,12 +13,12 @@ import (
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shutdown func(context.Context) error, err error) {
+// Metrics are exported to any readers given as well as to stdout.
+func setupOTelSDK(ctx context.Context, readers ...metric.Reader) (shutdown func(context.Context) error, err error) {
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -39,7 +39,7 @@ func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	}
 
 	// Setup resource.
-	res, err := newResource(serviceName, serviceVersion)
+	res, err := newResource(ctx)
 	if err != nil {
 		handleErr(err)
 		return
@@ -55,26 +55,23 @@ func setupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	otel.SetTracerProvider(tracerProvider)
 
 	// Setup meter provider.
-	meterProvider, err := newMeterProvider(res)
+	metricReader, err := newMetricReader()
 	if err != nil {
 		handleErr(err)
 		return
 	}
+	meterProvider := newMeterProvider(res, append(readers, metricReader)...)
 	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
 	otel.SetMeterProvider(meterProvider)
 
 	return
 }
 
-func newResource(serviceName, serviceVersion string) (*resource.Resource, error) {
-	return resource.Merge(resource.Default(),
-		resource.NewWithAttributes(semconv.SchemaURL,
-			semconv.ServiceName(serviceName),
-			semconv.ServiceVersion(serviceVersion),
-		))
-}
-
 func newTraceProvider(res *resource.Resource) (*trace.TracerProvider, error) {
+	if err := checkSampler(); err != nil {
+		return nil, err
+	}
+
 	traceExporter, err := stdouttrace.New(
 		stdouttrace.WithPrettyPrint())
 	if err != nil {
@@ -90,17 +87,13 @@ func newTraceProvider(res *resource.Resource) (*trace.TracerProvider, error) {
 	return traceProvider, nil
 }
 
-func newMeterProvider(res *resource.Resource) (*metric.MeterProvider, error) {
+func newMetricReader() (metric.Reader, error) {
 	metricExporter, err := stdoutmetric.New()
 	if err != nil {
 		return nil, err
 	}
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithResource(res),
-		metric.WithReader(metric.NewPeriodicReader(metricExporter,
-			// Default is 1m. Set to 3s for demonstrative purposes.
-			metric.WithInterval(3*time.Second))),
-	)
-	return meterProvider, nil
+	return metric.NewPeriodicReader(metricExporter,
+		// Default is 1m. Set to 3s for demonstrative purposes.
+		metric.WithInterval(3*time.Second)), nil
 }
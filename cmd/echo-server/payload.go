@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Payload kinds, all generated deterministically from a seed so that the
+// receiver can verify them.
+const (
+	payloadRandom  = "random"  // pseudo-random bytes from math/rand
+	payloadPattern = "pattern" // bytes counting up from the seed, mod 256
+	payloadZero    = "zero"    // zero bytes, ignoring the seed
+)
+
+// newPayload returns an endless reader of the given kind of payload.
+func newPayload(kind string, seed int64) (io.Reader, error) {
+	switch kind {
+	case payloadRandom, "":
+		return rand.New(rand.NewSource(seed)), nil
+	case payloadPattern:
+		return &patternReader{next: byte(seed)}, nil
+	case payloadZero:
+		return zeroReader{}, nil
+	default:
+		return nil, fmt.Errorf("unknown payload kind %q", kind)
+	}
+}
+
+type patternReader struct {
+	next byte
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// payloadParams returns the kind and seed of the payload requested by req.
+func payloadParams(req *http.Request) (string, int64, error) {
+	query := req.URL.Query()
+	var seed int64
+	if v := query.Get("seed"); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return "", 0, fmt.Errorf("invalid seed %q", v)
+		}
+	}
+	return query.Get("kind"), seed, nil
+}
+
+// servePayload responds to GET /.payload with size bytes of the payload of
+// the given kind and seed. The sha256 query parameter adds an X-Echo-Sha256
+// header, at the cost of generating the payload twice. It is not named digest
+// as that asks for the request body to be digested.
+func servePayload(wr http.ResponseWriter, req *http.Request) {
+	size, err := parseSize(req.URL.Query().Get("size"))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind, seed, err := payloadParams(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload, err := newPayload(kind, seed)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := req.URL.Query()["sha256"]; ok {
+		digest, _ := newPayload(kind, seed)
+		h := sha256.New()
+		io.CopyN(h, digest, size)
+		wr.Header().Set("X-Echo-Sha256", hex.EncodeToString(h.Sum(nil)))
+	}
+
+	wr.Header().Set("Content-Type", "application/octet-stream")
+	wr.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	wr.WriteHeader(http.StatusOK)
+
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.CopyN(wr, payload, size); err != nil {
+		slog.InfoContext(req.Context(), "payload write", "remote", req.RemoteAddr, "error", err)
+	}
+}
+
+// uploadResult reports the body received by the upload sink.
+type uploadResult struct {
+	Bytes          int64   `json:"bytes"`
+	SHA256         string  `json:"sha256"`
+	Duration       string  `json:"duration"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	Verified       *bool   `json:"verified,omitempty"`
+	MismatchAt     *int64  `json:"mismatchAt,omitempty"`
+}
+
+// serveUpload responds to POST or PUT /.upload by consuming the body without
+// buffering it, reporting its size, SHA-256 digest and the throughput. If the
+// kind or seed query parameters are given, the body is also verified against
+// that payload.
+func serveUpload(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		wr.Header().Set("Allow", "POST, PUT")
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h := sha256.New()
+	w := io.Writer(h)
+
+	var verifier *payloadVerifier
+	query := req.URL.Query()
+	if query.Has("kind") || query.Has("seed") {
+		kind, seed, err := payloadParams(req)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, err := newPayload(kind, seed)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		verifier = &payloadVerifier{payload: payload, mismatch: -1}
+		w = io.MultiWriter(h, verifier)
+	}
+
+	start := time.Now()
+	n, err := io.Copy(w, req.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		slog.WarnContext(req.Context(), "upload read", "remote", req.RemoteAddr, "bytes", n, "error", err)
+		if isTooLarge(err) {
+			http.Error(wr, tooLarge(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := uploadResult{
+		Bytes:    n,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		Duration: elapsed.String(),
+	}
+	if elapsed > 0 {
+		result.BytesPerSecond = float64(n) / elapsed.Seconds()
+	}
+	if verifier != nil {
+		verified := verifier.mismatch < 0
+		result.Verified = &verified
+		if !verified {
+			result.MismatchAt = &verifier.mismatch
+		}
+	}
+
+	slog.InfoContext(req.Context(), "upload",
+		"remote", req.RemoteAddr,
+		"bytes", result.Bytes,
+		"sha256", result.SHA256,
+		"duration", elapsed,
+	)
+
+	format := responseFormat(req)
+	wr.Header().Set("Content-Type", contentType(format))
+	wr.WriteHeader(http.StatusOK)
+
+	if format == formatJSON {
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return
+	}
+
+	fmt.Fprintf(wr, "Received %d bytes in %s (%.0f bytes/s, %.2f Mbit/s)\n", result.Bytes, result.Duration, result.BytesPerSecond, result.BytesPerSecond*8/1e6)
+	fmt.Fprintf(wr, "SHA-256: %s\n", result.SHA256)
+	if result.Verified != nil {
+		if *result.Verified {
+			fmt.Fprintln(wr, "Payload verified")
+		} else {
+			fmt.Fprintf(wr, "Payload mismatch at byte %d\n", *result.MismatchAt)
+		}
+	}
+}
+
+// payloadVerifier compares what is written to it with a payload, recording
+// the offset of the first mismatch.
+type payloadVerifier struct {
+	payload  io.Reader
+	offset   int64
+	mismatch int64
+	buf      []byte
+}
+
+func (v *payloadVerifier) Write(p []byte) (int, error) {
+	if v.mismatch >= 0 {
+		return len(p), nil
+	}
+
+	if cap(v.buf) < len(p) {
+		v.buf = make([]byte, len(p))
+	}
+	want := v.buf[:len(p)]
+	io.ReadFull(v.payload, want)
+
+	if !bytes.Equal(p, want) {
+		for i := range p {
+			if p[i] != want[i] {
+				v.mismatch = v.offset + int64(i)
+				break
+			}
+		}
+	}
+	v.offset += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// istioHeaders are the headers Istio needs an application to propagate for
+// its traces to join up.
+// https://istio.io/latest/docs/tasks/observability/distributed-tracing/overview/#trace-context-propagation
+var istioHeaders = []string{
+	"x-request-id",
+	"x-b3-*",
+	"b3",
+	"x-ot-span-context",
+	"x-cloud-trace-context",
+	"traceparent",
+	"tracestate",
+	"grpc-trace-bin",
+}
+
+// propagateHeaders matches the headers copied from a request to the calls of
+// its chain, set by PROPAGATE_HEADERS.
+var propagateHeaders = newHeaderMatcher(istioHeaders)
+
+// headerMatcher matches header names exactly or, for patterns ending with *,
+// by prefix, ignoring case.
+type headerMatcher struct {
+	names    map[string]bool
+	prefixes []string
+}
+
+// parseHeaderMatcher parses a comma separated list of header names and
+// prefix patterns such as x-b3-*. The name istio stands for istioHeaders.
+func parseHeaderMatcher(list string) *headerMatcher {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case strings.EqualFold(p, "istio"):
+			patterns = append(patterns, istioHeaders...)
+		default:
+			patterns = append(patterns, p)
+		}
+	}
+	return newHeaderMatcher(patterns)
+}
+
+func newHeaderMatcher(patterns []string) *headerMatcher {
+	m := &headerMatcher{names: map[string]bool{}}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			m.prefixes = append(m.prefixes, prefix)
+		} else {
+			m.names[p] = true
+		}
+	}
+	return m
+}
+
+// Match reports whether the named header is matched.
+func (m *headerMatcher) Match(name string) bool {
+	name = strings.ToLower(name)
+	if m.names[name] {
+		return true
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the patterns of the matcher.
+func (m *headerMatcher) String() string {
+	patterns := make([]string, 0, len(m.names)+len(m.prefixes))
+	for name := range m.names {
+		patterns = append(patterns, name)
+	}
+	for _, prefix := range m.prefixes {
+		patterns = append(patterns, prefix+"*")
+	}
+	sort.Strings(patterns)
+	return strings.Join(patterns, ",")
+}
+
+// PropagateEfxHeaders injects the span and baggage of ctx into req with the
+// global propagator, then copies the headers matched by propagateHeaders from
+// src to req, unless req already has them. Without the otel feature the
+// propagator does nothing, so the trace headers of src are passed on as they
+// are, as by a proxy that takes no part in the trace.
+func PropagateEfxHeaders(ctx context.Context, src *http.Request, req *http.Request) (context.Context, *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	for name, values := range src.Header {
+		if !propagateHeaders.Match(name) || len(req.Header.Values(name)) > 0 {
+			continue
+		}
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return ctx, req
+}
+
+// propagatedHeaders returns the headers of an outgoing request matched by
+// propagateHeaders, keyed by their lower case names, whether copied from the
+// incoming request or injected by the tracer.
+func propagatedHeaders(h http.Header) map[string]string {
+	var propagated map[string]string
+	for name, values := range h {
+		if !propagateHeaders.Match(name) {
+			continue
+		}
+		if propagated == nil {
+			propagated = map[string]string{}
+		}
+		propagated[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	return propagated
+}
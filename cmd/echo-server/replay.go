@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if _, _, ok := strings.Cut(value, ":"); !ok {
+		return fmt.Errorf("header %q is not Name: value", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// replayHeaders are not replayed, being set by the client for the new request.
+var replayHeaders = []string{
+	"Host", "Content-Length", "Connection", "Keep-Alive", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// replay re-sends the requests of a HAR file or URL, such as the /.requests.har
+// of another echo-server, to a target and reports the responses. It returns
+// the exit code.
+//
+//	echo-server replay -target http://localhost:8080 -rate 10 -H 'X-Env: test' requests.har
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := fs.String("target", "", "base `URL` to send the requests to, instead of their recorded host")
+	rate := fs.Float64("rate", 0, "requests per second, or 0 for as fast as possible")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each request")
+	var headers headerFlags
+	fs.Var(&headers, "H", "`header` to set on every request, as Name: value; an empty value removes it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: echo-server replay [flags] <file or URL of HAR>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var base *url.URL
+	if *target != "" {
+		var err error
+		if base, err = url.Parse(*target); err != nil || base.Host == "" {
+			fmt.Fprintf(os.Stderr, "invalid target %q\n", *target)
+			return 2
+		}
+	}
+
+	h, err := readHAR(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var failed, mismatched int
+	start := time.Now()
+	for i, entry := range h.Log.Entries {
+		if tick != nil && i > 0 {
+			<-tick
+		}
+
+		req, err := newReplayRequest(entry, base, headers)
+		if err != nil {
+			failed++
+			fmt.Printf("%s %s: %v\n", entry.Request.Method, entry.Request.URL, err)
+			continue
+		}
+
+		began := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			failed++
+			fmt.Printf("%s %s: %v\n", req.Method, req.URL, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		mark := ""
+		if entry.Response.Status != 0 && resp.StatusCode != entry.Response.Status {
+			mismatched++
+			mark = " !"
+		}
+		if req.ContentLength < entry.Request.BodySize {
+			mark += " (body truncated)"
+		}
+		fmt.Printf("%s %s: %d (recorded %d) %s%s\n", req.Method, req.URL, resp.StatusCode, entry.Response.Status,
+			time.Since(began).Round(time.Millisecond), mark)
+	}
+
+	fmt.Printf("replayed %d requests in %s | %d failed | %d status mismatches\n",
+		len(h.Log.Entries), time.Since(start).Round(time.Millisecond), failed, mismatched)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readHAR reads a HAR from a file or an http(s) URL.
+func readHAR(source string) (*har, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", source, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var h har
+	if err := json.NewDecoder(r).Decode(&h); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return &h, nil
+}
+
+// newReplayRequest returns the request of a HAR entry, sent to base if not
+// nil, with the given headers set.
+func newReplayRequest(entry harEntry, base *url.URL, headers []string) (*http.Request, error) {
+	u, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		u.Scheme, u.Host = base.Scheme, base.Host
+		u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+		u.RawPath = ""
+	}
+	if u.Host == "" {
+		return nil, errors.New("no host to send the request to; set -target")
+	}
+
+	var body io.Reader
+	if pd := entry.Request.PostData; pd != nil {
+		data, err := decodeHARText(pd.Text, pd.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(entry.Request.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nv := range entry.Request.Headers {
+		req.Header.Add(nv.Name, nv.Value)
+	}
+	for _, name := range replayHeaders {
+		req.Header.Del(name)
+	}
+
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Host"):
+			req.Host = value
+		case value == "":
+			req.Header.Del(name)
+		default:
+			req.Header.Set(name, value)
+		}
+	}
+	return req, nil
+}
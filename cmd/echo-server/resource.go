@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// serviceName is the service name reported to OpenTelemetry, unless
+// OTEL_SERVICE_NAME is set.
+const serviceName = "echo-server"
+
+// newResource returns the resource describing the server to OpenTelemetry:
+// the service, with its version from the build info, and any Kubernetes
+// attributes, all of which OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME
+// override so that one image can pose as many services.
+func newResource(ctx context.Context) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(buildVersion()),
+		),
+		resource.WithDetectors(k8sDetector{}),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("otel resource", "attributes", res)
+	return res, nil
+}
+
+// k8sEnv maps the environment variables that a pod spec may set from the
+// downward API to the resource attributes they give, preferring the first of
+// each.
+//
+//	env:
+//	  - name: K8S_POD_NAME
+//	    valueFrom:
+//	      fieldRef:
+//	        fieldPath: metadata.name
+var k8sEnv = []struct {
+	names []string
+	attr  func(string) attribute.KeyValue
+}{
+	{[]string{"K8S_POD_NAME", "POD_NAME"}, semconv.K8SPodName},
+	{[]string{"K8S_POD_UID", "POD_UID"}, semconv.K8SPodUID},
+	{[]string{"K8S_NAMESPACE_NAME", "POD_NAMESPACE"}, semconv.K8SNamespaceName},
+	{[]string{"K8S_NODE_NAME", "NODE_NAME"}, semconv.K8SNodeName},
+	{[]string{"K8S_CONTAINER_NAME"}, semconv.K8SContainerName},
+	{[]string{"K8S_CLUSTER_NAME"}, semconv.K8SClusterName},
+}
+
+// k8sDetector detects the Kubernetes pod the server runs in from the
+// environment variables of k8sEnv.
+type k8sDetector struct{}
+
+func (k8sDetector) Detect(context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	for _, e := range k8sEnv {
+		for _, name := range e.names {
+			if v := os.Getenv(name); v != "" {
+				attrs = append(attrs, e.attr(v))
+				break
+			}
+		}
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...), nil
+}
+
+// checkSampler checks OTEL_TRACES_SAMPLER, and OTEL_TRACES_SAMPLER_ARG for the
+// ratio samplers, which the SDK reads itself but would fall back to its
+// default for rather than fail.
+// https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/#general-sdk-configuration
+func checkSampler() error {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER")))
+	switch name {
+	case "", "always_on", "always_off", "parentbased_always_on", "parentbased_always_off":
+		return nil
+	case "traceidratio", "parentbased_traceidratio":
+	default:
+		return fmt.Errorf("OTEL_TRACES_SAMPLER %q is not supported", name)
+	}
+
+	arg := strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if arg == "" {
+		return nil
+	}
+	if ratio, err := strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+		return fmt.Errorf("OTEL_TRACES_SAMPLER_ARG %q is not a ratio from 0 to 1", arg)
+	}
+	return nil
+}
+
+// buildVersion returns the version of the server from its build info: the
+// module version, or else the VCS revision it was built from.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision string
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "(devel)"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
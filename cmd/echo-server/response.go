@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// maxJSONBody is the most of a request body embedded in a JSON response.
+const maxJSONBody = 1 << 20
+
+// echoResponse is the structured form of an echoed request. Its JSON encoding
+// is the documented schema returned when the json format is negotiated, so
+// fields should only ever be added to it.
+type echoResponse struct {
+	Host          string         `json:"host,omitempty"`
+	HostError     string         `json:"hostError,omitempty"`
+	Time          time.Time      `json:"time"`
+	Proto         string         `json:"proto"`
+	Method        string         `json:"method"`
+	URL           string         `json:"url"`
+	TLS           *tlsInfo       `json:"tls,omitempty"`
+	Trace         *traceInfo     `json:"trace,omitempty"`
+	Think         string         `json:"think,omitempty"`
+	Delay         string         `json:"delay,omitempty"`
+	Headers       http.Header    `json:"headers,omitempty"`
+	Env           []string       `json:"env,omitempty"`
+	Meta          string         `json:"meta,omitempty"`
+	Body          string         `json:"body,omitempty"`
+	BodyEncoding  string         `json:"bodyEncoding,omitempty"`
+	BodyTruncated bool           `json:"bodyTruncated,omitempty"`
+	BodyInfo      *bodyInfo      `json:"bodyInfo,omitempty"`
+	Form          *formInfo      `json:"form,omitempty"`
+	Chain         *chainResult   `json:"chain,omitempty"`
+	Fanout        []*chainResult `json:"fanout,omitempty"`
+}
+
+// newEchoResponse captures the parts of req that are always reported along
+// with those that have been requested and are enabled.
+func newEchoResponse(req *http.Request) *echoResponse {
+	resp := &echoResponse{
+		Time:   time.Now(),
+		Proto:  req.Proto,
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+
+	if req.TLS != nil {
+		resp.TLS = newTLSInfo(req.TLS)
+	}
+
+	if feature.Enabled("tracecontext") {
+		resp.Trace = newTraceInfo(req.Context())
+	}
+
+	host, err := os.Hostname()
+	if err == nil {
+		resp.Host = host
+	} else {
+		resp.HostError = err.Error()
+	}
+
+	// output request headers if requested
+	if _, ok := req.URL.Query()["headers"]; ok && feature.Enabled("headers") {
+		resp.Headers = req.Header.Clone()
+		resp.Headers.Set("Host", req.Host)
+	}
+
+	// dump environment if requested
+	if _, ok := req.URL.Query()["env"]; ok && feature.Enabled("env") {
+		resp.Env = os.Environ()
+	}
+
+	// dump meta if requested
+	if _, ok := req.URL.Query()["meta"]; ok && feature.Enabled("meta") {
+		resp.Meta = meta
+	}
+
+	return resp
+}
+
+// sleepFor sleeps for the duration given by the named query parameter, or its
+// default, when the feature of the same name is enabled, returning the
+// duration applied.
+func sleepFor(req *http.Request, name string) string {
+	value, ok := queryParam(req, name)
+	if ok && feature.Enabled(name) {
+		if d, err := time.ParseDuration(value); err == nil {
+			time.Sleep(d)
+			recordDelay(req.Context(), name, d)
+			return value
+		}
+	}
+	return ""
+}
+
+// responseFormat negotiates the response format, preferring an explicit
+// ?format= query over the Accept header.
+func responseFormat(req *http.Request) string {
+	if f := req.URL.Query().Get("format"); f != "" {
+		if strings.EqualFold(f, formatJSON) {
+			return formatJSON
+		}
+		return formatText
+	}
+
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json":
+			return formatJSON
+		case "text/plain", "text/*":
+			return formatText
+		}
+	}
+	return formatText
+}
+
+// contentType returns the media type used for the given response format.
+func contentType(format string) string {
+	if format == formatJSON {
+		return "application/json"
+	}
+	return "text/plain"
+}
+
+// writeEchoResponse renders resp in the negotiated format, echoing body after
+// the request details.
+func writeEchoResponse(wr http.ResponseWriter, req *http.Request, resp *echoResponse, body io.Reader) {
+	format := responseFormat(req)
+	wr.Header().Set("X-Echo-Host", resp.Host)
+	if resp.Trace != nil {
+		resp.Trace.setHeaders(wr.Header())
+	}
+
+	if format == formatJSON {
+		// the body is buffered to embed it, so only its start is, the rest
+		// still being read for the form and digest
+		br := bufio.NewReader(body)
+		data, err := io.ReadAll(io.LimitReader(br, maxJSONBody))
+		if err == nil && len(data) == maxJSONBody {
+			_, err = br.Peek(1)
+			resp.BodyTruncated = err == nil
+		}
+		if formRequested(req) {
+			resp.Form = parseForm(req.Header.Get("Content-Type"), io.MultiReader(bytes.NewReader(data), br))
+		}
+		if err == nil || err == io.EOF {
+			_, err = io.Copy(io.Discard, br)
+		}
+		if isTooLarge(err) {
+			http.Error(wr, tooLarge(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		// encoding/json would replace invalid UTF-8, so a binary body is
+		// base64 encoded, as in a HAR export
+		resp.Body, resp.BodyEncoding = harText(string(data))
+		if d, ok := bodyDigest(req.Context()); ok && digestRequested(req) {
+			resp.BodyInfo = d.info()
+		}
+		wr.Header().Set("Content-Type", contentType(format))
+		wr.WriteHeader(200)
+		enc := json.NewEncoder(wr)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(resp)
+		return
+	}
+
+	// echo the body as it is received, rather than have the server discard
+	// what is unread once the response starts, starting to read it before
+	// responding as a client waiting on Expect: 100-continue only sends it
+	// once asked to
+	http.NewResponseController(wr).EnableFullDuplex()
+	br := bufio.NewReader(body)
+	br.Peek(1)
+
+	wr.Header().Set("Content-Type", contentType(format))
+	wr.WriteHeader(200)
+	writeText(wr, resp)
+
+	var form *formInfo
+	if formRequested(req) {
+		// parse the form as it is echoed
+		form = parseForm(req.Header.Get("Content-Type"), io.TeeReader(br, wr))
+	}
+	if _, err := io.Copy(wr, br); isTooLarge(err) {
+		// too late to reject a body without a Content-Length
+		fmt.Fprintf(wr, "\n\n---- body truncated | %s\n", tooLarge())
+	}
+
+	if d, ok := bodyDigest(req.Context()); ok && digestRequested(req) {
+		fmt.Fprintf(wr, "\n\n---- body | %s\n", d.info().summary())
+	}
+
+	if form != nil {
+		fmt.Fprintf(wr, "\n\n---- form | %s\n\n", form.summary())
+		writeForm(wr, form)
+	}
+
+	if c := resp.Chain; c != nil {
+		fmt.Fprintf(wr, "\n\n---- chain | %s\n\n", c.summary())
+		if c.Error != "" {
+			fmt.Fprintf(wr, "chain call failed with %s\n", c.Error)
+			fmt.Fprintln(wr, "")
+		}
+		wr.Write(c.body)
+	}
+
+	for _, c := range resp.Fanout {
+		fmt.Fprintf(wr, "\n\n---- branch %s | %s\n\n", c.Branch, c.summary())
+		if c.Error != "" {
+			fmt.Fprintf(wr, "branch call failed with %s\n", c.Error)
+			fmt.Fprintln(wr, "")
+		}
+		wr.Write(c.body)
+	}
+}
+
+// writeText writes the request details of resp as text/plain.
+func writeText(wr io.Writer, resp *echoResponse) {
+	if resp.HostError == "" {
+		fmt.Fprintf(wr, "Request received by %s at %s\n\n", resp.Host, resp.Time.Format(time.RFC3339Nano))
+	} else {
+		fmt.Fprintf(wr, "Server hostname unknown: %s\n\n", resp.HostError)
+	}
+
+	fmt.Fprintf(wr, "%s %s %s\n", resp.Proto, resp.Method, resp.URL)
+	fmt.Fprintln(wr, "")
+
+	if t := resp.TLS; t != nil {
+		fmt.Fprintf(wr, "TLS: %s %s", t.Version, t.CipherSuite)
+		if t.ALPN != "" {
+			fmt.Fprintf(wr, " alpn=%s", t.ALPN)
+		}
+		if t.ServerName != "" {
+			fmt.Fprintf(wr, " sni=%s", t.ServerName)
+		}
+		if t.Resumed {
+			fmt.Fprint(wr, " resumed")
+		}
+		fmt.Fprintln(wr, "")
+		for _, c := range t.PeerCerts {
+			fmt.Fprintf(wr, "Client certificate: %s (issuer %s, verified %t)\n", c.Subject, c.Issuer, t.Verified)
+			if sans := c.sans(); len(sans) > 0 {
+				fmt.Fprintf(wr, "  SANs: %s\n", strings.Join(sans, ", "))
+			}
+		}
+		fmt.Fprintln(wr, "")
+	}
+
+	if resp.Trace != nil {
+		fmt.Fprintf(wr, "Trace: %s\n\n", resp.Trace.summary())
+	}
+
+	if resp.Think != "" {
+		fmt.Fprintf(wr, "Thinking for: %s\n\n", resp.Think)
+	}
+
+	if resp.Delay != "" {
+		fmt.Fprintf(wr, "Delayed by: %s\n\n", resp.Delay)
+	}
+
+	if resp.Headers != nil {
+		fmt.Fprintf(wr, "Host: %s\n", resp.Headers.Get("Host"))
+		keys := make([]string, 0, len(resp.Headers))
+		for key := range resp.Headers {
+			if key != "Host" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range resp.Headers[key] {
+				fmt.Fprintf(wr, "%s: %s\n", key, value)
+			}
+		}
+		fmt.Fprintln(wr, "")
+	}
+
+	if resp.Env != nil {
+		for _, e := range resp.Env {
+			fmt.Fprintf(wr, "%s\n", e)
+		}
+		fmt.Fprintln(wr, "")
+	}
+
+	if resp.Meta != "" {
+		fmt.Fprintf(wr, "%s\n\n", resp.Meta)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// routes serves the mock routes loaded from ROUTES_FILE, if any.
+var routes *http.ServeMux
+
+// routesFile is the format of ROUTES_FILE, in YAML or JSON.
+//
+//	routes:
+//	  - method: GET
+//	    path: /users/{id}
+//	    status: 200
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"id": {{ .Param "id" | json }}}'
+type routesFile struct {
+	Routes []route `yaml:"routes"`
+}
+
+// route is a canned response to requests matching its method and path, given
+// as a ServeMux pattern that may have path parameters. The body and header
+// values are text templates executed with a routeRequest.
+type route struct {
+	Method   string            `yaml:"method"`
+	Path     string            `yaml:"path"`
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"bodyFile"`
+	Delay    string            `yaml:"delay"`
+}
+
+// maxRouteBody is the most of a request body available to route templates.
+const maxRouteBody = 1 << 20
+
+// routeRequest is the data available to route templates.
+type routeRequest struct {
+	Method string
+	URL    *url.URL
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Host   string
+	Body   string
+
+	req *http.Request
+}
+
+// Param returns the value of the named path parameter.
+func (r *routeRequest) Param(name string) string {
+	return r.req.PathValue(name)
+}
+
+var routeFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// loadRoutes returns a handler serving the routes in the named file.
+func loadRoutes(file string) (*http.ServeMux, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is also YAML
+	var rf routesFile
+	if err := yaml.Unmarshal(data, &rf); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	mux := http.NewServeMux()
+	for i, r := range rf.Routes {
+		h, err := newRouteHandler(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", file, i, err)
+		}
+
+		pattern := r.Path
+		if r.Method != "" {
+			pattern = strings.ToUpper(r.Method) + " " + r.Path
+		}
+		if err := registerRoute(mux, pattern, h); err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", file, i, err)
+		}
+	}
+
+	slog.Info("loaded routes", "file", file, "routes", len(rf.Routes))
+	return mux, nil
+}
+
+// registerRoute registers h on mux, returning an error for an invalid or
+// conflicting pattern rather than panicking.
+func registerRoute(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+func newRouteHandler(r route) (http.Handler, error) {
+	if r.BodyFile != "" {
+		data, err := os.ReadFile(r.BodyFile)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = string(data)
+	}
+
+	body, err := template.New("body").Funcs(routeFuncs).Parse(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]*template.Template, len(r.Headers))
+	for name, value := range r.Headers {
+		if headers[name], err = template.New(name).Funcs(routeFuncs).Parse(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var delay time.Duration
+	if r.Delay != "" {
+		if delay, err = time.ParseDuration(r.Delay); err != nil {
+			return nil, err
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	} else if status < 100 || status > 999 {
+		return nil, fmt.Errorf("invalid status %d, must be from 100 to 999", status)
+	}
+
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxRouteBody))
+		if isTooLarge(err) {
+			http.Error(wr, tooLarge(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		rr := &routeRequest{
+			Method: req.Method,
+			URL:    req.URL,
+			Path:   req.URL.Path,
+			Query:  req.URL.Query(),
+			Header: req.Header,
+			Host:   req.Host,
+			Body:   string(data),
+			req:    req,
+		}
+
+		// render everything first so that a template error can be reported
+		var buf bytes.Buffer
+		if err := body.Execute(&buf, rr); err != nil {
+			slog.WarnContext(req.Context(), "route template", "error", err)
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for name, t := range headers {
+			var value strings.Builder
+			if err := t.Execute(&value, rr); err != nil {
+				slog.WarnContext(req.Context(), "route template", "header", name, "error", err)
+				http.Error(wr, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			wr.Header().Set(name, value.String())
+		}
+
+		if delay > 0 {
+			time.Sleep(delay)
+			recordDelay(req.Context(), "delay", delay)
+		}
+
+		wr.WriteHeader(status)
+		wr.Write(buf.Bytes())
+	}), nil
+}
+
+// serveRoute serves req from the mock routes, returning false if no route
+// matches it.
+func serveRoute(wr http.ResponseWriter, req *http.Request) bool {
+	if routes == nil {
+		return false
+	}
+	if _, pattern := routes.Handler(req); pattern == "" {
+		return false
+	}
+	routes.ServeHTTP(wr, req)
+	return true
+}
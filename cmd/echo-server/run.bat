@@ -2,6 +2,9 @@
 @REM SET ENABLE_FEATURES="delay,think,headers,env,otel,post,log"
 @SET ENABLE_FEATURES="nosignals,delay,think,headers,post,otel,timeout"
 @REM SET TRACE_GRPC=yes
+@REM SET METRICS_GRPC=yes
+@REM SET OTEL_LOGS_EXPORTER=otlp
+@REM SET LOGS_GRPC=yes
 @REM SET LOG_LEVEL=-4
 @REM SET LOG_JSON=yes
 @REM SET LOG_SOURCE=yes
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websockets tracks the open WebSocket connections so that they can be closed
+// cleanly when the server shuts down.
+var websockets = struct {
+	sync.Mutex
+	sync.WaitGroup
+	conns map[*websocket.Conn]struct{}
+}{
+	conns: map[*websocket.Conn]struct{}{},
+}
+
+// trackWebSocket registers conn as open until the returned func is called.
+func trackWebSocket(conn *websocket.Conn) func() {
+	websockets.Lock()
+	defer websockets.Unlock()
+
+	websockets.conns[conn] = struct{}{}
+	websockets.Add(1)
+	websocketConns.Add(context.Background(), 1)
+
+	return func() {
+		websockets.Lock()
+		defer websockets.Unlock()
+
+		delete(websockets.conns, conn)
+		websockets.Done()
+		websocketConns.Add(context.Background(), -1)
+	}
+}
+
+// closeWebSockets sends a going away close frame to every open WebSocket
+// connection, giving clients until the deadline to acknowledge it.
+func closeWebSockets(deadline time.Time) {
+	websockets.Lock()
+	defer websockets.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn := range websockets.conns {
+		if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+			slog.Debug("websocket close", "remote", conn.RemoteAddr(), "error", err)
+		}
+		conn.SetReadDeadline(deadline)
+	}
+}
+
+// h2cConns tracks the HTTP/2 connections taken over by the h2c handler, which
+// are hijacked from the servers.
+var h2cConns sync.WaitGroup
+
+// trackH2C tracks the connections h takes over, as the h2c handler does those
+// of a prior knowledge or upgrade request, serving them before it returns.
+func trackH2C(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		priorKnowledge := req.Method == "PRI" && req.URL.Path == "*" && req.Proto == "HTTP/2.0"
+		upgrade := strings.EqualFold(req.Header.Get("Upgrade"), "h2c")
+		if priorKnowledge || upgrade {
+			h2cConns.Add(1)
+			defer h2cConns.Done()
+		}
+		h.ServeHTTP(wr, req)
+	})
+}
+
+// shutdownHooks are run alongside the drain, to stop listeners that are not
+// http.Servers. They should return once ctx is done.
+var shutdownHooks []func(ctx context.Context)
+
+// shutdownServers stops the servers accepting new connections and drains
+// in-flight requests, WebSocket connections and h2c connections.
+//
+// SHUTDOWN_DELAY is an optional period to keep serving before the shutdown
+// begins, allowing load balancers to stop routing to the server (as for a
+// Kubernetes preStop sleep). The ready probe fails throughout. SHUTDOWN_DRAIN
+// bounds how long to wait for requests to complete before the remaining
+// connections are closed.
+func shutdownServers(servers ...*http.Server) {
+	shuttingDown.Store(true)
+
+	if delay := getenvDuration("SHUTDOWN_DELAY", 0); delay > 0 {
+		slog.Info("delaying shutdown", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	drain := getenvDuration("SHUTDOWN_DRAIN", 30*time.Second)
+	slog.Info("shutting down", "drain", drain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	// hijacked connections are not tracked by the servers, so close the
+	// websockets alongside the drain, whereas the h2c connections are sent a
+	// GOAWAY by the servers as they shut down
+	deadline, _ := ctx.Deadline()
+	go closeWebSockets(deadline)
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error("shutdown", "addr", srv.Addr, "error", err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	for _, hook := range shutdownHooks {
+		wg.Add(1)
+		go func(hook func(context.Context)) {
+			defer wg.Done()
+			hook(ctx)
+		}(hook)
+	}
+	wg.Wait()
+
+	if err := waitHijacked(ctx, &websockets.WaitGroup, "websockets"); err != nil {
+		slog.Error("shutdown", "error", err)
+	}
+	if err := waitHijacked(ctx, &h2cConns, "h2c connections"); err != nil {
+		slog.Error("shutdown", "error", err)
+	}
+}
+
+// waitHijacked waits for the hijacked connections tracked by wg to finish.
+func waitHijacked(ctx context.Context, wg *sync.WaitGroup, what string) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Join(errors.New(what+" still open"), ctx.Err())
+	}
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -20,3 +21,15 @@ func signalContext() context.Context {
 	}()
 	return ctx
 }
+
+// toggle the ready probe on SIGUSR2
+func notifyHealthSignals() {
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGUSR2)
+	go func() {
+		for range signalC {
+			up := readyProbe.Toggle()
+			slog.Info("probe changed", "probe", readyProbe.name, "up", up)
+		}
+	}()
+}
@@ -25,3 +25,6 @@ func signalContext() context.Context {
 		return context.Background()
 	}
 }
+
+// there is no signal to toggle the ready probe on windows
+func notifyHealthSignals() {}
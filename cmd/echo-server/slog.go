@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"strconv"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logLevel is the minimum level of records that are logged.
+var logLevel = new(slog.LevelVar)
+
 func logInit() {
 	var logSource bool
-	var logLevel int
 
 	if os.Getenv("LOG_SOURCE") != "" {
 		logSource = true
@@ -16,22 +22,102 @@ func logInit() {
 
 	if ll := os.Getenv("LOG_LEVEL"); ll != "" {
 		if i, err := strconv.Atoi(ll); err == nil {
-			logLevel = i
+			logLevel.Set(slog.Level(i))
 		}
 	}
 
 	handlerOptions := &slog.HandlerOptions{
-		Level:     slog.Level(logLevel),
+		Level:     logLevel,
 		AddSource: logSource,
 	}
 
 	var logger *slog.Logger
 	if os.Getenv("LOG_JSON") != "" {
 		jh := slog.NewJSONHandler(os.Stdout, handlerOptions)
-		logger = slog.New(jh)
+		logger = slog.New(traceHandler{jh})
 	} else {
 		th := slog.NewTextHandler(os.Stdout, handlerOptions)
-		logger = slog.New(th)
+		logger = slog.New(traceHandler{th})
 	}
 	slog.SetDefault(logger)
 }
+
+// traceHandler adds the IDs of the span in the context to each record.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// teeHandler passes each record to all of its handlers.
+type teeHandler []slog.Handler
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			err = errors.Join(err, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	tee := make(teeHandler, len(h))
+	for i, handler := range h {
+		tee[i] = handler.WithAttrs(attrs)
+	}
+	return tee
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	tee := make(teeHandler, len(h))
+	for i, handler := range h {
+		tee[i] = handler.WithGroup(name)
+	}
+	return tee
+}
+
+// leveledHandler drops records below its level, for handlers that have no
+// level of their own.
+type leveledHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return leveledHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h leveledHandler) WithGroup(name string) slog.Handler {
+	return leveledHandler{h.Handler.WithGroup(name), h.level}
+}
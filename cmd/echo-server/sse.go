@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serveSSE streams Server-Sent Events, so as to test how proxies buffer and
+// time out long-lived responses.
+//
+// Events are sent every interval (1s by default) until count events have been
+// sent, or forever if count is not given. Each event's data is padded to size
+// bytes. A client reconnecting with a Last-Event-ID header resumes after that
+// event. The retry query parameter sets the reconnection time advertised to
+// the client.
+//
+// With the fault feature, disconnect=N drops the connection after N events,
+// taking an optional probability as the other faults do.
+func serveSSE(wr http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	interval := time.Second
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d <= 0 {
+			http.Error(wr, fmt.Sprintf("invalid interval %q", v), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	count, err := queryInt(req, "count", 0)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, err := queryInt(req, "size", 0)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	disconnect := -1
+	if spec, ok := faultParam(req, "disconnect"); ok && feature.Enabled("fault") {
+		if v, inject := parseFault(spec); inject {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				disconnect = n
+				wr.Header().Set("X-Echo-Fault", "disconnect="+v)
+				recordFault(req.Context(), "disconnect")
+			}
+		}
+	}
+
+	// resume after the last event received by the client
+	id := 0
+	if v := req.Header.Get("Last-Event-ID"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			id = n
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	rc := http.NewResponseController(wr)
+	wr.Header().Set("Content-Type", "text/event-stream")
+	wr.Header().Set("Cache-Control", "no-cache")
+	wr.Header().Set("X-Echo-Host", host)
+	wr.WriteHeader(http.StatusOK)
+
+	if v := query.Get("retry"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			fmt.Fprintf(wr, "retry: %d\n\n", d.Milliseconds())
+		}
+	}
+	rc.Flush()
+
+	slog.InfoContext(req.Context(), "sse stream", "remote", req.RemoteAddr, "last_event_id", id)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for sent := 0; count == 0 || id < count; sent++ {
+		if sent == disconnect {
+			slog.InfoContext(req.Context(), "sse disconnect", "remote", req.RemoteAddr, "last_event_id", id)
+			panic(http.ErrAbortHandler)
+		}
+
+		if sent > 0 {
+			select {
+			case <-ticker.C:
+			case <-req.Context().Done():
+				return
+			}
+		}
+
+		// end the stream rather than hold up a graceful shutdown
+		if shuttingDown.Load() {
+			return
+		}
+
+		id++
+		data := fmt.Sprintf("Event %d served by %s at %s", id, host, time.Now().Format(time.RFC3339Nano))
+		if pad := size - len(data); pad > 0 {
+			data += " " + strings.Repeat(".", pad-1)
+		}
+
+		if _, err := fmt.Fprintf(wr, "id: %d\nevent: echo\ndata: %s\n\n", id, data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// queryInt returns the named non-negative integer query parameter, or def if
+// it is not given.
+func queryInt(req *http.Request, name string, def int) (int, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
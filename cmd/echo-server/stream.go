@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamLine is the pattern repeated to fill streamed responses.
+const streamLine = "echo-server streamed response 0123456789 abcdefghijklmnopqrstuvwxyz\n"
+
+// maxStreamChunk is the largest chunk a stream can be written in, as each
+// chunk is held in memory.
+const maxStreamChunk = 1 << 20
+
+// serveStream streams a response of stream=N bytes in chunks, to reproduce
+// slow downloads and test response timeouts and buffering.
+//
+//   - chunk is the size of each chunk written, 1KiB by default and at most
+//     1MiB
+//   - interval is a period to wait between chunks
+//   - rate caps the bandwidth, in bytes per second
+//   - flush=false leaves the chunks to be buffered rather than flushing each
+//   - trailers sends X-Echo-Bytes and X-Echo-Duration trailers
+//
+// Sizes are in bytes, or with a k, M or G suffix (or KiB, MiB and GiB).
+func serveStream(wr http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	total, err := parseSize(query.Get("stream"))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chunk := int64(1024)
+	if v := query.Get("chunk"); v != "" {
+		if chunk, err = parseSize(v); err != nil || chunk == 0 || chunk > maxStreamChunk {
+			http.Error(wr, fmt.Sprintf("invalid chunk %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var interval time.Duration
+	if v := query.Get("interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var rate int64
+	if v := query.Get("rate"); v != "" {
+		if rate, err = parseSize(v); err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	flush := query.Get("flush") != "false"
+	_, trailers := query["trailers"]
+
+	rc := http.NewResponseController(wr)
+	wr.Header().Set("Content-Type", "text/plain")
+	if trailers {
+		wr.Header().Set("Trailer", "X-Echo-Bytes, X-Echo-Duration")
+	}
+	wr.WriteHeader(http.StatusOK)
+
+	// the pattern is repeated so that a chunk can be sliced at any offset
+	pattern := strings.Repeat(streamLine, int(chunk)/len(streamLine)+2)
+
+	start := time.Now()
+	next := start
+	var written int64
+	for written < total {
+		// wait for both the interval and the bandwidth cap to allow the next
+		// chunk
+		if rate > 0 {
+			if due := start.Add(time.Duration(float64(written) / float64(rate) * float64(time.Second))); due.After(next) {
+				next = due
+			}
+		}
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-req.Context().Done():
+				return
+			}
+		}
+
+		n := min(chunk, total-written)
+		offset := written % int64(len(streamLine))
+		if _, err := wr.Write([]byte(pattern[offset : offset+n])); err != nil {
+			slog.InfoContext(req.Context(), "stream write", "remote", req.RemoteAddr, "written", written, "error", err)
+			return
+		}
+		written += n
+
+		if flush {
+			rc.Flush()
+		}
+		next = time.Now().Add(interval)
+	}
+
+	if trailers {
+		wr.Header().Set("X-Echo-Bytes", strconv.FormatInt(written, 10))
+		wr.Header().Set("X-Echo-Duration", time.Since(start).String())
+	}
+}
+
+// parseSize parses a size in bytes, which may have a decimal (k, M, G) or
+// binary (KiB, MiB, GiB) suffix.
+func parseSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	}
+
+	s, scale := v, int64(1)
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			s, scale = n, u.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/scale {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * scale, nil
+}
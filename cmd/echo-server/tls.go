@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlsInfo describes the negotiated TLS connection of a request.
+type tlsInfo struct {
+	Version     string        `json:"version"`
+	CipherSuite string        `json:"cipherSuite"`
+	ALPN        string        `json:"alpn,omitempty"`
+	ServerName  string        `json:"serverName,omitempty"`
+	Resumed     bool          `json:"resumed"`
+	PeerCerts   []certificate `json:"peerCertificates,omitempty"`
+	Verified    bool          `json:"peerVerified"`
+}
+
+// certificate describes a client certificate presented on a TLS connection.
+type certificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"notAfter"`
+	DNSNames []string  `json:"dnsNames,omitempty"`
+	URIs     []string  `json:"uris,omitempty"`
+	Emails   []string  `json:"emails,omitempty"`
+	IPs      []string  `json:"ips,omitempty"`
+	CA       bool      `json:"ca"`
+}
+
+func newTLSInfo(cs *tls.ConnectionState) *tlsInfo {
+	info := &tlsInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		ServerName:  cs.ServerName,
+		Resumed:     cs.DidResume,
+		Verified:    len(cs.VerifiedChains) > 0,
+	}
+
+	for _, c := range cs.PeerCertificates {
+		cert := certificate{
+			Subject:  c.Subject.String(),
+			Issuer:   c.Issuer.String(),
+			Serial:   c.SerialNumber.String(),
+			NotAfter: c.NotAfter,
+			DNSNames: c.DNSNames,
+			Emails:   c.EmailAddresses,
+			CA:       c.IsCA,
+		}
+		for _, u := range c.URIs {
+			cert.URIs = append(cert.URIs, u.String())
+		}
+		for _, ip := range c.IPAddresses {
+			cert.IPs = append(cert.IPs, ip.String())
+		}
+		info.PeerCerts = append(info.PeerCerts, cert)
+	}
+
+	return info
+}
+
+// sans returns the subject alternative names of the certificate.
+func (c certificate) sans() []string {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	sans = append(sans, c.URIs...)
+	sans = append(sans, c.Emails...)
+	sans = append(sans, c.IPs...)
+	return sans
+}
+
+// newTLSConfig builds the configuration of the HTTPS listener.
+//
+// The certificate is loaded from TLS_CERT_FILE and TLS_KEY_FILE. If they are
+// not set a certificate is issued by a freshly generated CA, which is written
+// to TLS_GENERATED_CA_FILE if that is set so that clients can trust it.
+//
+// Client certificates are verified against TLS_CLIENT_CA_FILE, and
+// TLS_CLIENT_AUTH selects whether they are requested and required.
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, caPEM, err := generateCertificate(tlsHosts())
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+
+		if caFile := os.Getenv("TLS_GENERATED_CA_FILE"); caFile != "" {
+			if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	switch mode := os.Getenv("TLS_CLIENT_AUTH"); strings.ToLower(mode) {
+	case "":
+	case "none":
+		config.ClientAuth = tls.NoClientCert
+	case "request":
+		config.ClientAuth = tls.RequestClientCert
+	case "require":
+		config.ClientAuth = tls.RequireAnyClientCert
+	case "verify":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require-verify":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown TLS_CLIENT_AUTH %q", mode)
+	}
+
+	if config.ClientAuth >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil {
+		return nil, errors.New("TLS_CLIENT_CA_FILE is required to verify client certificates")
+	}
+
+	return config, nil
+}
+
+// tlsHosts returns the names the generated certificate is valid for, which
+// are the hostname, localhost and any listed in TLS_HOSTS.
+func tlsHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, err := os.Hostname(); err == nil {
+		hosts = append(hosts, host)
+	}
+	for _, h := range strings.FieldsFunc(os.Getenv("TLS_HOSTS"), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// generateCertificate creates a self-signed CA and uses it to issue a server
+// certificate for hosts, returning the certificate and the PEM encoded CA.
+func generateCertificate(hosts []string) (tls.Certificate, []byte, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "echo-server CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "echo-server"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  key,
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return cert, caPEM, nil
+}
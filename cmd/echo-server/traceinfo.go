@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceInfo describes the span serving a request and the baggage it carries,
+// as extracted by the propagator.
+type traceInfo struct {
+	TraceID    string          `json:"traceId,omitempty"`
+	SpanID     string          `json:"spanId,omitempty"`
+	Sampled    bool            `json:"sampled"`
+	TraceState string          `json:"traceState,omitempty"`
+	Baggage    []baggageMember `json:"baggage,omitempty"`
+
+	sc trace.SpanContext
+}
+
+// baggageMember is a member of the baggage of a request.
+type baggageMember struct {
+	Key        string            `json:"key"`
+	Value      string            `json:"value"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// newTraceInfo returns the span context and baggage of ctx, or nil if it has
+// neither.
+func newTraceInfo(ctx context.Context) *traceInfo {
+	sc := trace.SpanContextFromContext(ctx)
+	bag := baggage.FromContext(ctx)
+	if !sc.IsValid() && bag.Len() == 0 {
+		return nil
+	}
+
+	t := &traceInfo{sc: sc}
+	if sc.IsValid() {
+		t.TraceID = sc.TraceID().String()
+		t.SpanID = sc.SpanID().String()
+		t.Sampled = sc.IsSampled()
+		t.TraceState = sc.TraceState().String()
+	}
+
+	for _, m := range bag.Members() {
+		member := baggageMember{Key: m.Key(), Value: m.Value()}
+		for _, p := range m.Properties() {
+			if member.Properties == nil {
+				member.Properties = map[string]string{}
+			}
+			member.Properties[p.Key()], _ = p.Value()
+		}
+		t.Baggage = append(t.Baggage, member)
+	}
+	sort.Slice(t.Baggage, func(i, j int) bool { return t.Baggage[i].Key < t.Baggage[j].Key })
+	return t
+}
+
+// traceparent returns the span context in the W3C traceparent format.
+func (t *traceInfo) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.sc.TraceID(), t.sc.SpanID(), t.sc.TraceFlags())
+}
+
+// setHeaders reports the span to the client in a traceresponse header, from
+// the draft of Trace Context Level 2, and as the traceparent of Server-Timing
+// for browsers.
+func (t *traceInfo) setHeaders(h http.Header) {
+	if !t.sc.IsValid() {
+		return
+	}
+	h.Set("traceresponse", t.traceparent())
+	h.Add("Server-Timing", fmt.Sprintf("traceparent;desc=%q", t.traceparent()))
+}
+
+// summary describes the span and baggage in a single line.
+func (t *traceInfo) summary() string {
+	var b strings.Builder
+	if t.sc.IsValid() {
+		fmt.Fprintf(&b, "trace %s span %s", t.TraceID, t.SpanID)
+		if t.Sampled {
+			b.WriteString(" sampled")
+		} else {
+			b.WriteString(" not sampled")
+		}
+		if t.TraceState != "" {
+			fmt.Fprintf(&b, " | tracestate %s", t.TraceState)
+		}
+	} else {
+		b.WriteString("no span")
+	}
+	for i, m := range t.Baggage {
+		if i == 0 {
+			b.WriteString(" | baggage ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=%s", m.Key, m.Value)
+	}
+	return b.String()
+}
+
+// withBaggage returns ctx with members added to its baggage, replacing any of
+// the same key. Invalid members are logged and skipped.
+func withBaggage(ctx context.Context, members map[string]string) context.Context {
+	bag := baggage.FromContext(ctx)
+	for key, value := range members {
+		m, err := baggage.NewMemberRaw(key, value)
+		if err == nil {
+			bag, err = bag.SetMember(m)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "invalid baggage", "key", key, "error", err)
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}